@@ -1,3 +1,3 @@
 module github.com/insolar/sm-uml-gen
 
-go 1.14
+go 1.18
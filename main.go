@@ -1,28 +1,99 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+
+	"github.com/insolar/sm-uml-gen/smuml"
 )
 
 func main() {
-	path := flag.String("f", "", "Path to go file")
+	// stdout can be used for output
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	path := flag.String("f", "", "Path to go file, or - to read it from stdin")
+	name := flag.String("name", "stdin.go", "Logical filename of a source read from stdin, "+
+		"it is used for positions and to detect a version of smachine API")
 	console := flag.Bool("c", false, "Print uml diagram to console")
+	output := flag.String("o", "", "Write all diagrams to this file, - for stdout. By default diagrams are written "+
+		"next to the source, or to stdout when the source is read from stdin")
+	usages := flag.String("u", "all", "Comma separated categories of context calls to show on steps: "+
+		"log, defaults, shared, sync, child, bargein, other, all or none")
+	labels := smuml.DefaultLabelPolicy()
+	labelMode := flag.String("l", "abbrev", "Mode of condition labels: abbrev, full or hidden")
+	flag.IntVar(&labels.MaxCondLen, "cond-len", labels.MaxCondLen, "Max length of a condition in abbrev mode")
+	flag.IntVar(&labels.MaxArgLen, "arg-len", labels.MaxArgLen, "Max length of an operation argument")
+	flag.IntVar(&labels.MaxKeyLen, "key-len", labels.MaxKeyLen, "Max length of a key of shared data or of a sync object")
+	flag.IntVar(&labels.WrapLen, "wrap", labels.WrapLen, "Wrap labels longer than this, 0 to disable")
+	flag.BoolVar(&labels.CondTable, "t", false, "Number conditions and list their full text in a side table")
+	opsFile := flag.String("ops", "", "Path to JSON file that overrides the catalogue of smachine operations")
+	api := flag.String("api", "", "Version of smachine API: legacy or current, by default it is detected by the framework source used by each module")
 	flag.Parse()
 
-	fs := NewFileSet()
-	// if fs.smachinePkg != "" {
-	// 	name := `\Insolar\go\src\github.com\insolar\assured-ledger\ledger-core\virtual\execute\execute.go`
-	// 	fs.AddFile(name)
-	// 	fs.WriteUMLs(false)
-	// 	return
-	// }
+	var err error
+	if labels.Mode, err = smuml.ParseLabelMode(*labelMode); err != nil {
+		return err
+	}
+	for _, limit := range []struct {
+		name string
+		n    int
+	}{{"cond-len", labels.MaxCondLen}, {"arg-len", labels.MaxArgLen}, {"key-len", labels.MaxKeyLen}} {
+		if limit.n < 0 {
+			return fmt.Errorf("-%s can't be negative: %d", limit.name, limit.n)
+		}
+	}
 
-	if path == nil || *path == "" {
-		fmt.Print("Error: Path was not specified\n")
-		return
+	fs := smuml.NewFileSet()
+	fs.SetUsageFilter(*usages)
+	fs.SetLabelPolicy(labels)
+	if err := fs.SetAPIVersion(*api); err != nil {
+		return err
+	}
+	if *opsFile != "" {
+		if err := fs.LoadOperations(*opsFile); err != nil {
+			return err
+		}
+	}
+	if *path == "" {
+		return errors.New("path was not specified")
 	}
 
-	fs.AddFile(*path)
-	fs.WriteUMLs(console != nil && *console)
+	if *path == "-" {
+		err = fs.AddReader(*name, os.Stdin)
+	} else {
+		err = fs.AddFile(*path)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case *output != "" && *output != "-":
+		err = writeFile(fs, *output)
+	default:
+		err = fs.WriteUMLs(*console || *path == "-" || *output == "-")
+	}
+	for _, msg := range fs.Warnings() {
+		_, _ = fmt.Fprintln(os.Stderr, "Warning:", msg)
+	}
+	return err
+}
+
+func writeFile(fs *smuml.FileSet, output string) (err error) {
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return fs.Render(file, smuml.FormatPlantUML)
 }
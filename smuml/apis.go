@@ -0,0 +1,292 @@
+package smuml
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// LatestAPI is a name of the latest release of smachine API, it is used when a release can't be detected
+const LatestAPI = "current"
+
+// APIVersion is a release of smachine API. Its operations are applied over DefaultOpCatalogue.
+type APIVersion struct {
+	Name     string
+	Declares []string    // context methods declared by the framework since this release, they detect the release by its source
+	Ops      OpCatalogue // operations that differ from the latest API
+}
+
+// APIVersions returns known releases of smachine API, the oldest first
+func APIVersions() []APIVersion {
+	return []APIVersion{
+		{
+			Name:     "legacy",
+			Declares: []string{"ThenRepeatOrElse"},
+			Ops: OpCatalogue{
+				// ThenRepeatOrElse() has no args, it repeats the step after the wait or lets the step go on
+				"ThenRepeatOrElse": {Role: OpJump, Then: true},
+			},
+		},
+		{
+			Name:     LatestAPI,
+			Declares: []string{"ThenRepeatOrJump", "ThenRepeatOrJumpExt"},
+		},
+	}
+}
+
+// FindAPIVersion returns a release of smachine API by name
+func FindAPIVersion(name string) (APIVersion, error) {
+	var names []string
+	for _, v := range APIVersions() {
+		if v.Name == name {
+			return v, nil
+		}
+		names = append(names, v.Name)
+	}
+	return APIVersion{}, fmt.Errorf("unknown smachine API version %q, expected one of: %s", name, strings.Join(names, ", "))
+}
+
+// DetectAPIVersion picks the latest release of smachine API with all of its methods declared by the framework source,
+// the latest API is used when the source is not available
+func DetectAPIVersion(declared map[string]bool) APIVersion {
+	versions := APIVersions()
+	if len(declared) == 0 {
+		return versions[len(versions)-1]
+	}
+next:
+	for i := len(versions) - 1; i >= 0; i-- {
+		for _, name := range versions[i].Declares {
+			if !declared[name] {
+				continue next
+			}
+		}
+		return versions[i]
+	}
+	return versions[len(versions)-1]
+}
+
+// declaredMethods returns names of methods declared by the package in the dir, either by interfaces or by types
+func declaredMethods(dir string) map[string]bool {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var names map[string]bool
+	add := func(id *ast.Ident) {
+		if id == nil {
+			return
+		}
+		if names == nil {
+			names = map[string]bool{}
+		}
+		names[id.Name] = true
+	}
+
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") || strings.HasSuffix(e.Name(), "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, e.Name()), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch op := n.(type) {
+			case *ast.FuncDecl:
+				if op.Recv != nil {
+					add(op.Name)
+				}
+				return false
+			case *ast.InterfaceType:
+				for _, m := range op.Methods.List {
+					if _, ok := m.Type.(*ast.FuncType); ok && len(m.Names) > 0 {
+						add(m.Names[0])
+					}
+				}
+			}
+			return true
+		})
+	}
+	return names
+}
+
+// findModuleFile returns the nearest go.mod of a source file, or an empty string
+func findModuleFile(filename string) string {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return ""
+	}
+	for {
+		modFile := filepath.Join(dir, "go.mod")
+		if fi, err := os.Stat(modFile); err == nil && !fi.IsDir() {
+			return modFile
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// modInfo is a part of go.mod that is needed to find a source of a required package
+type modInfo struct {
+	path     string
+	requires map[string]string // module path -> version
+	replaces map[string]string // module path -> a local dir, or a module path and a version separated by space
+}
+
+// readModFile reads the module path, requirements and replacements of the go.mod
+func readModFile(modFile string) *modInfo {
+	file, err := os.Open(modFile)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info := &modInfo{requires: map[string]string{}, replaces: map[string]string{}}
+	block := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if n := strings.Index(line, "//"); n >= 0 {
+			line = line[:n]
+		}
+		fields := strings.Fields(line)
+
+		directive := block
+		switch {
+		case len(fields) == 0:
+			continue
+		case block != "":
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+		case len(fields) > 1 && fields[1] == "(":
+			block = fields[0]
+			continue
+		default:
+			directive, fields = fields[0], fields[1:]
+		}
+
+		switch directive {
+		case "module":
+			if len(fields) > 0 {
+				info.path = strings.Trim(fields[0], `"`)
+			}
+		case "require":
+			if len(fields) > 1 {
+				info.requires[strings.Trim(fields[0], `"`)] = fields[1]
+			}
+		case "replace":
+			// old [version] => new [version]
+			for i, f := range fields {
+				if f == "=>" && i+1 < len(fields) {
+					info.replaces[strings.Trim(fields[0], `"`)] = strings.Join(fields[i+1:], " ")
+					break
+				}
+			}
+		}
+	}
+	return info
+}
+
+// provider returns a path of the module that provides the package, the module itself has an empty version
+func (m *modInfo) provider(pkgPath string) (modPath, version string, ok bool) {
+	provides := func(modPath string) bool {
+		return pkgPath == modPath || strings.HasPrefix(pkgPath, modPath+"/")
+	}
+	if provides(m.path) {
+		return m.path, "", true
+	}
+	for path, v := range m.requires {
+		if provides(path) && len(path) > len(modPath) {
+			modPath, version, ok = path, v, true
+		}
+	}
+	return modPath, version, ok
+}
+
+// packageDir returns a directory with the source of the package as it is used by the module of the go.mod:
+// the module itself, its vendor directory, a local replacement or the module cache
+func packageDir(modFile, pkgPath string) string {
+	info := readModFile(modFile)
+	if info == nil {
+		return ""
+	}
+	modPath, version, ok := info.provider(pkgPath)
+	if !ok {
+		return ""
+	}
+	modDir := filepath.Dir(modFile)
+	rel := filepath.FromSlash(strings.TrimPrefix(strings.TrimPrefix(pkgPath, modPath), "/"))
+	if modPath == info.path {
+		return filepath.Join(modDir, rel)
+	}
+
+	var dirs []string
+	dirs = append(dirs, filepath.Join(modDir, "vendor", filepath.FromSlash(pkgPath)))
+	if replace := info.replaces[modPath]; replace != "" {
+		target := strings.Fields(replace)
+		switch {
+		case len(target) == 1 && (filepath.IsAbs(target[0]) || strings.HasPrefix(target[0], ".")):
+			dir := target[0]
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(modDir, dir)
+			}
+			dirs = append(dirs, filepath.Join(dir, rel))
+		case len(target) == 2:
+			modPath, version = target[0], target[1]
+		}
+	}
+	if cache := moduleCacheDir(); cache != "" && version != "" {
+		dirs = append(dirs, filepath.Join(cache, escapeModulePath(modPath)+"@"+version, rel))
+	}
+
+	for _, dir := range dirs {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+func moduleCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	if gopath := filepath.SplitList(os.Getenv("GOPATH")); len(gopath) > 0 && gopath[0] != "" {
+		return filepath.Join(gopath[0], "pkg", "mod")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, "go", "pkg", "mod")
+	}
+	return ""
+}
+
+// escapeModulePath escapes upper case letters as the module cache does, e.g. !foo for Foo
+func escapeModulePath(path string) string {
+	b := strings.Builder{}
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return filepath.FromSlash(b.String())
+}
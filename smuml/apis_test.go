@@ -0,0 +1,209 @@
+package smuml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSmachinePkg = "github.com/insolar/assured-ledger/ledger-core/conveyor/smachine"
+
+func TestDetectAPIVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		declared map[string]bool
+		want     string
+	}{
+		{"no source", nil, LatestAPI},
+		{"legacy", map[string]bool{"ThenRepeatOrElse": true}, "legacy"},
+		{"current", map[string]bool{"ThenRepeatOrElse": true, "ThenRepeatOrJump": true, "ThenRepeatOrJumpExt": true}, LatestAPI},
+		{"unknown", map[string]bool{"Jump": true}, LatestAPI},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DetectAPIVersion(tc.declared).Name; got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := FindAPIVersion("unknown"); err == nil {
+		t.Error("expected an error for an unknown API version")
+	}
+}
+
+func writeTestFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadModFile(t *testing.T) {
+	modFile := filepath.Join(t.TempDir(), "go.mod")
+	writeTestFile(t, modFile, `module example.com/app // the app
+
+go 1.18
+
+require github.com/insolar/assured-ledger/ledger-core v0.1.0
+
+require (
+	github.com/insolar/assured-ledger v0.0.1 // indirect
+	example.com/other v1.2.3
+)
+
+replace github.com/insolar/assured-ledger/ledger-core => ../ledger-core
+
+replace (
+	example.com/other v1.2.3 => example.com/fork v1.2.4
+)
+`)
+	info := readModFile(modFile)
+	if info == nil {
+		t.Fatal("go.mod is not read")
+	}
+	if info.path != "example.com/app" {
+		t.Errorf("module path: %s", info.path)
+	}
+	if info.replaces["github.com/insolar/assured-ledger/ledger-core"] != "../ledger-core" ||
+		info.replaces["example.com/other"] != "example.com/fork v1.2.4" {
+		t.Errorf("replaces: %v", info.replaces)
+	}
+
+	for _, tc := range []struct {
+		pkgPath, modPath, version string
+		ok                        bool
+	}{
+		{testSmachinePkg, "github.com/insolar/assured-ledger/ledger-core", "v0.1.0", true},
+		{"github.com/insolar/assured-ledger/network", "github.com/insolar/assured-ledger", "v0.0.1", true},
+		{"example.com/app/sm", "example.com/app", "", true},
+		{"example.com/unknown", "", "", false},
+	} {
+		t.Run(tc.pkgPath, func(t *testing.T) {
+			modPath, version, ok := info.provider(tc.pkgPath)
+			if modPath != tc.modPath || version != tc.version || ok != tc.ok {
+				t.Errorf("got %s %s %v", modPath, version, ok)
+			}
+		})
+	}
+}
+
+func TestPackageDir(t *testing.T) {
+	root := t.TempDir()
+	cache := filepath.Join(root, "cache")
+	t.Setenv("GOMODCACHE", cache)
+
+	const require = "\nrequire github.com/insolar/assured-ledger/ledger-core v0.1.0\n"
+	for _, tc := range []struct {
+		name   string
+		modDir string
+		goMod  string
+		pkgDir string
+	}{
+		{
+			name:   "module itself",
+			modDir: "self",
+			goMod:  "module github.com/insolar/assured-ledger/ledger-core\n",
+			pkgDir: "self/conveyor/smachine",
+		},
+		{
+			name:   "vendor",
+			modDir: "vendor",
+			goMod:  "module example.com/vendor" + require,
+			pkgDir: "vendor/vendor/" + testSmachinePkg,
+		},
+		{
+			name:   "local replacement",
+			modDir: "replace",
+			goMod:  "module example.com/replace" + require + "replace github.com/insolar/assured-ledger/ledger-core => ../fork\n",
+			pkgDir: "fork/conveyor/smachine",
+		},
+		{
+			name:   "module cache",
+			modDir: "cache_app",
+			goMod:  "module example.com/cache" + require,
+			pkgDir: "cache/github.com/insolar/assured-ledger/ledger-core@v0.1.0/conveyor/smachine",
+		},
+		{
+			name:   "replacement in module cache",
+			modDir: "moved",
+			goMod:  "module example.com/moved" + require + "replace github.com/insolar/assured-ledger/ledger-core => github.com/Someone/ledger-core v0.2.0\n",
+			pkgDir: "cache/github.com/!someone/ledger-core@v0.2.0/conveyor/smachine",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modDir := filepath.Join(root, tc.modDir)
+			writeTestFile(t, filepath.Join(modDir, "go.mod"), tc.goMod)
+			want := filepath.Join(root, filepath.FromSlash(tc.pkgDir))
+			writeTestFile(t, filepath.Join(want, "ctx.go"), "package smachine\n")
+
+			if got := packageDir(filepath.Join(modDir, "go.mod"), testSmachinePkg); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestDeclaredMethods(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "ctx.go"), `package smachine
+
+type ConditionalBuilder interface {
+	// Deprecated: to be removed
+	ThenRepeatOrElse() (StateUpdate, bool)
+	ThenRepeatOrJump(fn StateFunc) StateUpdate
+}
+
+type slotContext struct{}
+
+func (p *slotContext) Jump(fn StateFunc) StateUpdate { return StateUpdate{} }
+
+func helper() {}
+`)
+	writeTestFile(t, filepath.Join(dir, "ctx_test.go"), "package smachine\n\nfunc (p *slotContext) TestOnly() {}\n")
+
+	got := declaredMethods(dir)
+	for _, name := range []string{"ThenRepeatOrElse", "ThenRepeatOrJump", "Jump"} {
+		if !got[name] {
+			t.Errorf("%s is not found in %v", name, got)
+		}
+	}
+	for _, name := range []string{"helper", "TestOnly"} {
+		if got[name] {
+			t.Errorf("%s is not a method", name)
+		}
+	}
+}
+
+func TestEscapeModulePath(t *testing.T) {
+	if got, want := escapeModulePath("github.com/BurntSushi/toml"), filepath.FromSlash("github.com/!burnt!sushi/toml"); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestLegacyAPI(t *testing.T) {
+	const src = `
+type SMOld struct {
+	smachine.StateMachineDeclTemplate
+	ready bool
+}
+
+func (s *SMOld) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if !s.ready {
+		return ctx.Sleep().ThenRepeatOrElse()
+	}
+	return ctx.Stop()
+}
+`
+	edges := renderEdges(t, src, func(fs *FileSet) {
+		if err := fs.SetAPIVersion("legacy"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	requireEdges(t, edges,
+		"Init --[dashed]> Init : [!s.ready]\\nSleep",
+		"Init --> [*] : [s.ready]",
+	)
+}
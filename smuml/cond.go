@@ -0,0 +1,356 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// switchCases builds conditions of cases: `tag == A` of a value switch, `x.(type) is A` of a type switch,
+// or the case expression itself of a switch without a tag
+type switchCases struct {
+	tag    ast.Expr
+	isType bool
+}
+
+func (s switchCases) term(c ast.Expr, negated bool) ast.Expr {
+	switch {
+	case s.isType && s.tag != nil:
+		is := ` is `
+		if negated {
+			is = ` is not `
+		}
+		return ast.NewIdent(types.ExprString(s.tag) + `.(type)` + is + types.ExprString(c))
+	case s.tag != nil:
+		c = &ast.BinaryExpr{X: s.tag, Op: token.EQL, Y: c}
+	}
+	if negated {
+		return negateCond(c)
+	}
+	return c
+}
+
+// caseCond builds a condition of a switch case, e.g. `switch tag { case A, B: }` gives `tag == A || tag == B`
+func (s switchCases) caseCond(list []ast.Expr) ast.Expr {
+	var cond ast.Expr
+	for _, c := range list {
+		c = s.term(c, false)
+		if cond == nil {
+			cond = c
+		} else {
+			cond = &ast.BinaryExpr{X: cond, Op: token.LOR, Y: c}
+		}
+	}
+	return cond
+}
+
+// rangeCond builds a condition of clauses from..to, each of them but the last one falls through to the next,
+// e.g. `case A: fallthrough; case B:` gives `tag == A || tag == B`
+func (s switchCases) rangeCond(clauses []ast.Stmt, from, to int) ast.Expr {
+	var list []ast.Expr
+	hasDefault := false
+	for _, cl := range clauses[from : to+1] {
+		if cc, ok := cl.(*ast.CaseClause); ok {
+			list = append(list, cc.List...)
+			hasDefault = hasDefault || cc.List == nil
+		}
+	}
+	cond := s.caseCond(list)
+	if s.tag == nil && cond != nil {
+		cond = conjoinCond(s.notCond(clauses[:from]), cond)
+	}
+	if hasDefault {
+		cond = disjoinCond(cond, s.notCond(clauses))
+	}
+	return cond
+}
+
+// notCond builds a negation of the given cases, e.g. for a default case or for preceding cases
+func (s switchCases) notCond(clauses []ast.Stmt) ast.Expr {
+	var cond ast.Expr
+	for _, cl := range clauses {
+		cc, ok := cl.(*ast.CaseClause)
+		if !ok || cc.List == nil {
+			continue
+		}
+		for _, c := range cc.List {
+			cond = conjoinCond(cond, s.term(c, true))
+		}
+	}
+	return cond
+}
+
+// allTerminate returns true when there is no default case and every case leaves the switch by other means than
+// a break of the switch, so statements after the switch are reached only when no case matches
+func (s switchCases) allTerminate(body *ast.BlockStmt, label string) bool {
+	if body == nil || len(body.List) == 0 {
+		return false
+	}
+	breaksSwitch := func(op *ast.BranchStmt) bool {
+		return op.Tok == token.BREAK && (op.Label == nil || op.Label.Name == label)
+	}
+	for _, cl := range body.List {
+		if cc, ok := cl.(*ast.CaseClause); !ok || cc.List == nil || !endsFlow(cc.Body, breaksSwitch) || hasBreak(cc.Body, label) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasBreak returns true when the list has a break of the enclosing switch or select
+func hasBreak(list []ast.Stmt, label string) bool {
+	found := false
+	depth := 0 // of nested statements that are left by an unlabeled break
+	var nested []bool
+	for _, stmt := range list {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			if n == nil {
+				if nested[len(nested)-1] {
+					depth--
+				}
+				nested = nested[:len(nested)-1]
+				return false
+			}
+			isNested := false
+			switch op := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+				isNested = true
+				depth++
+			case *ast.BranchStmt:
+				if op.Tok == token.BREAK && (op.Label == nil && depth == 0 || op.Label != nil && op.Label.Name == label) {
+					found = true
+				}
+			}
+			nested = append(nested, isNested)
+			return true
+		})
+	}
+	return found
+}
+
+// negateCond builds a negation of the given condition and simplifies it when possible
+func negateCond(cond ast.Expr) ast.Expr {
+	switch op := cond.(type) {
+	case *ast.ParenExpr:
+		return negateCond(op.X)
+	case *ast.Ident:
+		switch op.Name {
+		case "true":
+			return ast.NewIdent("false")
+		case "false":
+			return ast.NewIdent("true")
+		}
+	case *ast.UnaryExpr:
+		if op.Op == token.NOT {
+			return unparenCond(op.X)
+		}
+	case *ast.BinaryExpr:
+		if neg, ok := negatedComparison[op.Op]; ok {
+			return &ast.BinaryExpr{X: op.X, Op: neg, Y: op.Y}
+		}
+		switch op.Op {
+		case token.LAND:
+			return disjoinCond(negateCond(op.X), negateCond(op.Y))
+		case token.LOR:
+			return conjoinCond(negateCond(op.X), negateCond(op.Y))
+		}
+		return &ast.UnaryExpr{Op: token.NOT, X: &ast.ParenExpr{X: cond}}
+	}
+	return &ast.UnaryExpr{Op: token.NOT, X: cond}
+}
+
+var negatedComparison = map[token.Token]token.Token{
+	token.EQL: token.NEQ,
+	token.NEQ: token.EQL,
+	token.LSS: token.GEQ,
+	token.GEQ: token.LSS,
+	token.GTR: token.LEQ,
+	token.LEQ: token.GTR,
+}
+
+func unparenCond(cond ast.Expr) ast.Expr {
+	for {
+		op, ok := cond.(*ast.ParenExpr)
+		if !ok {
+			return cond
+		}
+		cond = op.X
+	}
+}
+
+// splitConjunction splits a && b && c into separate terms
+func splitConjunction(cond ast.Expr, terms []ast.Expr) []ast.Expr {
+	cond = unparenCond(cond)
+	if op, ok := cond.(*ast.BinaryExpr); ok && op.Op == token.LAND {
+		terms = splitConjunction(op.X, terms)
+		return splitConjunction(op.Y, terms)
+	}
+	if id, ok := cond.(*ast.Ident); ok && id.Name == "true" {
+		return terms
+	}
+	return append(terms, cond)
+}
+
+// splitDisjunction splits a || b || c into separate terms
+func splitDisjunction(cond ast.Expr, terms []ast.Expr) []ast.Expr {
+	cond = unparenCond(cond)
+	if op, ok := cond.(*ast.BinaryExpr); ok && op.Op == token.LOR {
+		terms = splitDisjunction(op.X, terms)
+		return splitDisjunction(op.Y, terms)
+	}
+	return append(terms, cond)
+}
+
+// simplifyConds conjoins the conditions into a list of distinct terms. A disjunction is dropped when one of its terms
+// is known to be true, and its terms known to be false are dropped. Returns false when the conditions contradict.
+func simplifyConds(conds []ast.Expr) ([]ast.Expr, bool) {
+	var terms []ast.Expr
+	for _, c := range conds {
+		terms = splitConjunction(c, terms)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		known := map[string]bool{}
+		for _, t := range terms {
+			if !isDisjunction(unparenCond(t)) {
+				known[types.ExprString(unparenCond(t))] = true
+			}
+		}
+
+		result := terms[:0:0]
+		seen := map[string]bool{}
+		for _, t := range terms {
+			t = unparenCond(t)
+			if !isDisjunction(t) {
+				s := types.ExprString(t)
+				if known[types.ExprString(negateCond(t))] {
+					return nil, false
+				}
+				if !seen[s] {
+					seen[s] = true
+					result = append(result, t)
+				}
+				continue
+			}
+
+			var rest ast.Expr
+			isTrue := false
+			for _, d := range splitDisjunction(t, nil) {
+				switch {
+				case known[types.ExprString(d)]:
+					isTrue = true
+				case !known[types.ExprString(negateCond(d))]:
+					rest = disjoinCond(rest, d)
+				}
+			}
+			switch {
+			case isTrue:
+				changed = true
+			case rest == nil:
+				return nil, false
+			default:
+				s := types.ExprString(rest)
+				changed = changed || s != types.ExprString(t)
+				if !seen[s] {
+					seen[s] = true
+					result = splitConjunction(rest, result)
+				}
+			}
+		}
+		terms = result
+	}
+	return terms, true
+}
+
+func isDisjunction(cond ast.Expr) bool {
+	op, ok := cond.(*ast.BinaryExpr)
+	return ok && op.Op == token.LOR
+}
+
+// getTypeSwitchTag returns x of `switch x.(type)` or of `switch v := x.(type)`
+func getTypeSwitchTag(assign ast.Stmt) ast.Expr {
+	var expr ast.Expr
+	switch op := assign.(type) {
+	case *ast.ExprStmt:
+		expr = op.X
+	case *ast.AssignStmt:
+		if len(op.Rhs) == 1 {
+			expr = op.Rhs[0]
+		}
+	}
+	if ta, ok := expr.(*ast.TypeAssertExpr); ok {
+		return ta.X
+	}
+	return nil
+}
+
+func conjoinCond(cond, term ast.Expr) ast.Expr {
+	if cond == nil {
+		return term
+	}
+	return &ast.BinaryExpr{X: parenDisjunction(cond), Op: token.LAND, Y: parenDisjunction(term)}
+}
+
+func parenDisjunction(cond ast.Expr) ast.Expr {
+	if isDisjunction(cond) {
+		return &ast.ParenExpr{X: cond}
+	}
+	return cond
+}
+
+func disjoinCond(cond, term ast.Expr) ast.Expr {
+	switch {
+	case cond == nil:
+		return term
+	case term == nil:
+		return cond
+	}
+	return &ast.BinaryExpr{X: cond, Op: token.LOR, Y: term}
+}
+
+// passCond returns a condition to reach statements after the if, e.g. !a && !b for
+// `if a { return } else if b { return }`, or nil when the if doesn't narrow it
+func passCond(op *ast.IfStmt) ast.Expr {
+	if op.Body == nil || !isTerminating(op.Body.List) {
+		return nil
+	}
+	cond := negateCond(op.Cond)
+	if elseIf, ok := op.Else.(*ast.IfStmt); ok {
+		if elseCond := passCond(elseIf); elseCond != nil {
+			cond = conjoinCond(cond, elseCond)
+		}
+	}
+	return cond
+}
+
+// isTerminating returns true when the list ends with a return, panic, break, continue or goto
+func isTerminating(list []ast.Stmt) bool {
+	return endsFlow(list, nil)
+}
+
+// endsFlow is isTerminating where stays tells which of branch statements don't leave the enclosing statement
+func endsFlow(list []ast.Stmt, stays func(*ast.BranchStmt) bool) bool {
+	if len(list) == 0 {
+		return false
+	}
+	switch op := list[len(list)-1].(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.ExprStmt:
+		if call, ok := op.X.(*ast.CallExpr); ok {
+			if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "panic" {
+				return true
+			}
+		}
+	case *ast.BranchStmt:
+		return op.Tok != token.FALLTHROUGH && (stays == nil || !stays(op))
+	case *ast.BlockStmt:
+		return endsFlow(op.List, stays)
+	case *ast.IfStmt:
+		return op.Else != nil && endsFlow(op.Body.List, stays) && endsFlow([]ast.Stmt{op.Else}, stays)
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"testing"
+)
+
+func TestNegateCond(t *testing.T) {
+	for _, tc := range []struct {
+		cond, want string
+	}{
+		{"a", "!a"},
+		{"!a", "a"},
+		{"!(a || b)", "a || b"},
+		{"a == b", "a != b"},
+		{"a < b", "a >= b"},
+		{"a && b", "!a || !b"},
+		{"a || b && !c", "!a && (!b || c)"},
+		{"a + b", "!(a + b)"},
+		{"(a > b)", "a <= b"},
+		{"true", "false"},
+	} {
+		expr, err := parser.ParseExpr(tc.cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := types.ExprString(negateCond(expr)); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.cond, got, tc.want)
+		}
+	}
+}
+
+func TestSimplifyConds(t *testing.T) {
+	for _, tc := range []struct {
+		conds []string
+		want  string
+		ok    bool
+	}{
+		{[]string{"a", "b && a"}, "a && b", true},
+		{[]string{"!a", "a || !b"}, "!a && !b", true},
+		{[]string{"a", "a || b", "c"}, "a && c", true},
+		{[]string{"!a", "!b", "a || b"}, "", false},
+		{[]string{"a", "b", "!a"}, "", false},
+		{[]string{"a || b", "c || d"}, "(a || b) && (c || d)", true},
+	} {
+		var conds []ast.Expr
+		for _, c := range tc.conds {
+			expr, err := parser.ParseExpr(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conds = append(conds, expr)
+		}
+		terms, ok := simplifyConds(conds)
+		if ok != tc.ok {
+			t.Errorf("%q: got %v, want %v", tc.conds, ok, tc.ok)
+			continue
+		}
+		var got ast.Expr
+		for _, term := range terms {
+			got = conjoinCond(got, term)
+		}
+		if ok && types.ExprString(got) != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.conds, types.ExprString(got), tc.want)
+		}
+	}
+}
+
+func TestSwitchWithTag(t *testing.T) {
+	const src = `
+type SMCond struct {
+	smachine.StateMachineDeclTemplate
+	mode int
+}
+
+func (s *SMCond) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	switch s.mode {
+	case 1, 2:
+		return ctx.Jump(s.stepA)
+	default:
+		return ctx.Stop()
+	}
+}
+
+func (s *SMCond) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepA : [s.mode==1||s.mode==2]",
+		"Init --> [*] : [s.mode!=1 && s.mode!=2]",
+	)
+}
+
+func TestSwitchWithoutTag(t *testing.T) {
+	const src = `
+type SMCond struct {
+	smachine.StateMachineDeclTemplate
+	x int
+}
+
+func (s *SMCond) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	switch {
+	case s.x > 1:
+		return ctx.Jump(s.stepA)
+	case s.x < 0:
+		return ctx.Stop()
+	}
+	return ctx.Jump(s.Init)
+}
+
+func (s *SMCond) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	edges := renderEdges(t, src)
+	requireEdges(t, edges,
+		"Init --> stepA : [s.x>1]",
+		"Init --> [*] : [s.x<=1 && s.x<0]",
+		// all cases return, so the code after the switch is reached only when no case matches
+		"Init --> Init : [s.x<=1 && s.x>=0]",
+	)
+	rejectEdges(t, edges, "Init --> Init")
+}
+
+func TestTypeSwitch(t *testing.T) {
+	const src = `
+type SMCond struct {
+	smachine.StateMachineDeclTemplate
+	val interface{}
+}
+
+func (s *SMCond) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	switch s.val.(type) {
+	case int:
+		return ctx.Jump(s.stepA)
+	}
+	return ctx.Stop()
+}
+
+func (s *SMCond) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepA : [s.val.(type) is int]",
+		"Init --> [*] : [s.val.(type) is not int]",
+	)
+}
+
+func TestElseIfChain(t *testing.T) {
+	const src = `
+type SMCond struct {
+	smachine.StateMachineDeclTemplate
+	x int
+}
+
+func (s *SMCond) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if s.x == 1 {
+		return ctx.Jump(s.stepA)
+	} else if s.x > 5 {
+		return ctx.Stop()
+	}
+	return ctx.Jump(s.stepA)
+}
+
+func (s *SMCond) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepA : [s.x==1]",
+		"Init --> [*] : [s.x!=1 && s.x>5]",
+		"Init --> stepA : [s.x!=1 && s.x<=5]",
+	)
+}
+
+func TestFallthrough(t *testing.T) {
+	const src = `
+type SMCond struct {
+	smachine.StateMachineDeclTemplate
+	n int
+}
+
+func (s *SMCond) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	switch s.n {
+	case 3:
+		ctx.SetDefaultFlags(smachine.StepPriority)
+		fallthrough
+	case 4:
+		return ctx.Jump(s.stepA)
+	case 5:
+		fallthrough
+	default:
+		return ctx.Stop()
+	}
+}
+
+func (s *SMCond) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	sm := resolveSM(t, src, "SMCond")
+	flags := map[string]string{}
+	for _, tr := range sm.Steps["Init"].Transitions {
+		flags[tr.Condition] = tr.StepFlags
+	}
+	if len(flags) != 3 || flags["[s.n==3]"] != "priority" || flags["[s.n==4]"] != "" {
+		t.Errorf("unexpected transitions: %v", flags)
+	}
+
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepA : [s.n==3]",
+		"Init --> stepA : [s.n==4]",
+		"Init --> [*] : [s.n==5||s.n!=3&&s.n!=4&&s.n!=5]",
+	)
+}
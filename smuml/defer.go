@@ -0,0 +1,129 @@
+package smuml
+
+import "go/ast"
+
+func (p *execTrace) isInDefer() bool {
+	for et := p; et != nil; et = et.parent {
+		if et.inDefer {
+			return true
+		}
+	}
+	return false
+}
+
+// addExit adds a transition made by an exit of the func. Deferred calls are traced in the reverse order
+// for every exit, so SetDefault* calls made by them apply to the transition, and a deferred func that
+// assigns a named StateUpdate result adds its own transitions.
+func (p *execTrace) addExit(su *stateUpdate) {
+	if p.isInDefer() {
+		return
+	}
+
+	et := p
+	overridden := false
+	var overrideCond ast.Expr
+	for i := len(p.deferred) - 1; i >= 0; i-- {
+		call := p.deferred[i]
+		dt := et.spawn()
+		dt.inDefer = true
+
+		if lit, ok := call.Fun.(*ast.FuncLit); ok {
+			if lit.Body != nil {
+				dt._parseStatements(lit.Body.List)
+				cond, always := assignCond(lit.Body.List, p.md.UpdateArg)
+				switch {
+				case always:
+					overridden = true
+				case cond != nil:
+					overrideCond = disjoinCond(overrideCond, cond)
+				}
+			}
+		} else {
+			dt.parseCallToCtx(call)
+		}
+
+		if root := p.root(); !root.deferCounted[call] {
+			if root.deferCounted == nil {
+				root.deferCounted = map[*ast.CallExpr]bool{}
+			}
+			root.deferCounted[call] = true
+			p.collectUsages(dt)
+		}
+		et = dt
+	}
+
+	switch {
+	case overridden:
+	case overrideCond != nil:
+		// the exit is reached when deferred funcs don't assign the result
+		et.spawnCond(negateCond(overrideCond), "").addTransition(su)
+	default:
+		et.addTransition(su)
+	}
+}
+
+// addDeferredAssignment adds a transition when a deferred func assigns a named StateUpdate result
+func (p *execTrace) addDeferredAssignment(lhs []ast.Expr) {
+	name := p.md.UpdateArg
+	if name == "" || !p.isInDefer() {
+		return
+	}
+	for _, l := range lhs {
+		if id, ok := l.(*ast.Ident); ok && id.Name == name {
+			if su := p.getTraced(name); su != nil && su != contextMarker {
+				p.addTransition(su)
+			}
+			return
+		}
+	}
+}
+
+// assignCond returns a condition under which the name is assigned by the list,
+// always is true when the name is assigned unconditionally
+func assignCond(list []ast.Stmt, name string) (cond ast.Expr, always bool) {
+	if name == "" {
+		return nil, false
+	}
+	for _, stmt := range list {
+		switch op := stmt.(type) {
+		case *ast.AssignStmt:
+			for _, l := range op.Lhs {
+				if id, ok := l.(*ast.Ident); ok && id.Name == name {
+					return nil, true
+				}
+			}
+		case *ast.BlockStmt:
+			c, a := assignCond(op.List, name)
+			if a {
+				return nil, true
+			}
+			cond = disjoinCond(cond, c)
+		case *ast.IfStmt:
+			bodyCond, bodyAlways := assignCond(op.Body.List, name)
+			var elseCond ast.Expr
+			elseAlways := false
+			if op.Else != nil {
+				elseCond, elseAlways = assignCond([]ast.Stmt{op.Else}, name)
+			}
+			if bodyAlways && elseAlways {
+				return nil, true
+			}
+			cond = disjoinCond(cond, branchAssignCond(op.Cond, bodyCond, bodyAlways))
+			cond = disjoinCond(cond, branchAssignCond(negateCond(op.Cond), elseCond, elseAlways))
+		case *ast.ReturnStmt:
+			return cond, false
+		}
+	}
+	return cond, false
+}
+
+func branchAssignCond(branch, cond ast.Expr, always bool) ast.Expr {
+	switch {
+	case always:
+		return branch
+	case cond != nil:
+		return conjoinCond(branch, cond)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestAssignCond(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		cond   string
+		always bool
+	}{
+		{"unconditional", "su = x", "", true},
+		{"if", "if a { su = x }", "a", false},
+		{"if else", "if a { su = x } else { su = y }", "", true},
+		{"else", "if a { } else { su = y }", "!a", false},
+		{"nested", "if a { if b { su = x } }", "a && b", false},
+		{"other name", "if a { other = x }", "", false},
+	} {
+		file, err := parser.ParseFile(token.NewFileSet(), "", "package p; func f() {"+tc.body+"}", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cond, always := assignCond(file.Decls[0].(*ast.FuncDecl).Body.List, "su")
+		got := ""
+		if cond != nil {
+			got = types.ExprString(cond)
+		}
+		if got != tc.cond || always != tc.always {
+			t.Errorf("%s: got %q %v, want %q %v", tc.name, got, always, tc.cond, tc.always)
+		}
+	}
+}
+
+func TestDeferredSetting(t *testing.T) {
+	const src = `
+type SMDefer struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMDefer) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	defer ctx.SetDefaultMigration(s.migrate)
+	return ctx.Jump(s.stepA)
+}
+
+func (s *SMDefer) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+
+func (s *SMDefer) migrate(ctx smachine.MigrationContext) smachine.StateUpdate {
+	return ctx.Stay()
+}
+`
+	requireEdges(t, renderEdges(t, src), "Init --> stepA : Migrate: s.migrate")
+}
+
+func TestDeferredAssignment(t *testing.T) {
+	const src = `
+type SMDefer struct {
+	smachine.StateMachineDeclTemplate
+	ok bool
+}
+
+func (s *SMDefer) Init(ctx smachine.InitializationContext) (su smachine.StateUpdate) {
+	defer ctx.Jump(s.stepB)
+	defer func() {
+		if !s.ok {
+			su = ctx.Jump(s.stepB)
+		}
+	}()
+	return ctx.Stop()
+}
+
+func (s *SMDefer) stepB(ctx smachine.ExecutionContext) (su smachine.StateUpdate) {
+	defer func() {
+		su = ctx.Jump(s.Init)
+	}()
+	return ctx.Stop()
+}
+`
+	sm := resolveSM(t, src, "SMDefer")
+	if usages := sm.Steps["Init"].Usages; usages["Jump"] != 0 {
+		t.Errorf("a deferred StateUpdate constructor is counted: %v", usages)
+	}
+
+	edges := renderEdges(t, src)
+	// the conditional assignment overrides the returned Stop only when its condition holds
+	requireEdges(t, edges,
+		"Init --> stepB : [!s.ok]",
+		"Init --> [*] : [s.ok]",
+		"stepB --> Init",
+	)
+	rejectEdges(t, edges, "stepB --> [*]")
+}
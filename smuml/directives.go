@@ -0,0 +1,121 @@
+package smuml
+
+import (
+	"go/ast"
+	"strings"
+)
+
+const directivePrefix = "//smuml:"
+
+const (
+	directiveJump     = "jump"     // //smuml:jump stepX [label] - adds a transition
+	directiveIgnore   = "ignore"   // //smuml:ignore [stepX] - hides a step, a statement or transitions to stepX
+	directiveName     = "name"     // //smuml:name Human readable name - renames a step
+	directiveGroup    = "group"    // //smuml:group GroupName - puts a step into a composite state
+	directiveTerminal = "terminal" // //smuml:terminal [label] - adds a transition to the final state
+)
+
+type directive struct {
+	Verb string
+	Arg  string
+}
+
+// parseDirectives extracts //smuml: directives from comments, these are not included into CommentGroup.Text()
+func parseDirectives(groups ...*ast.CommentGroup) []directive {
+	var result []directive
+	for _, cg := range groups {
+		if cg == nil {
+			continue
+		}
+		for _, c := range cg.List {
+			if !strings.HasPrefix(c.Text, directivePrefix) {
+				continue
+			}
+			s := strings.TrimSpace(c.Text[len(directivePrefix):])
+			d := directive{Verb: s}
+			if n := strings.IndexAny(s, " \t"); n >= 0 {
+				d.Verb, d.Arg = s[:n], strings.TrimSpace(s[n+1:])
+			}
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+func (p *sourceFile) directivesOf(node ast.Node) []directive {
+	if node == nil || p.comments == nil {
+		return nil
+	}
+	return parseDirectives(p.comments[node]...)
+}
+
+// applyDirectives applies function level directives, returns false when the step should be ignored
+func (p *MethodDecl) applyDirectives(directives []directive) bool {
+	for _, d := range directives {
+		switch d.Verb {
+		case directiveIgnore:
+			if d.Arg == "" {
+				return false
+			}
+			p.removeTransitionsTo(d.Arg)
+		case directiveName:
+			p.Label = d.Arg
+		case directiveGroup:
+			p.Group = d.Arg
+		case directiveJump:
+			target, label := splitDirectiveArg(d.Arg)
+			if target != "" {
+				p.addTransition(MethodTransition{Transition: target, Comment: label, InheritMigration: true,
+					InheritErrorHandler: true, InheritStepFlags: true})
+			}
+		case directiveTerminal:
+			p.addTransition(MethodTransition{Transition: TerminalStop, Comment: d.Arg})
+		}
+	}
+	return true
+}
+
+func (p *MethodDecl) removeTransitionsTo(target string) {
+	transitions := p.Transitions[:0]
+	for _, tr := range p.Transitions {
+		if tr.Transition == target || strings.HasSuffix(tr.Transition, `.`+target) {
+			continue
+		}
+		transitions = append(transitions, tr)
+	}
+	p.setTransitions(transitions)
+}
+
+func splitDirectiveArg(arg string) (first, rest string) {
+	if n := strings.IndexAny(arg, " \t"); n >= 0 {
+		return arg[:n], strings.TrimSpace(arg[n+1:])
+	}
+	return arg, ""
+}
+
+// applyDirectives applies statement level directives, returns true when the statement should be skipped
+func (p *execTrace) applyDirectives(stmt ast.Stmt) bool {
+	for _, d := range p.fs.directivesOf(stmt) {
+		switch d.Verb {
+		case directiveIgnore:
+			return true
+		case directiveJump:
+			target, label := splitDirectiveArg(d.Arg)
+			if target != "" {
+				p.addDirectiveTransition(target, label)
+			}
+		case directiveTerminal:
+			p.addDirectiveTransition(TerminalStop, d.Arg)
+		}
+	}
+	return false
+}
+
+func (p *execTrace) addDirectiveTransition(target, label string) {
+	mt := MethodTransition{Transition: target, Comment: label}
+	mt.Condition, mt.FullCondition = p.buildCondition()
+	if target != TerminalStop {
+		mt.InheritMigration, mt.InheritErrorHandler, mt.InheritStepFlags = true, true, true
+	}
+	p.md.addTransition(mt)
+}
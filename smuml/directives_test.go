@@ -0,0 +1,37 @@
+package smuml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoredStep(t *testing.T) {
+	const src = `
+type SMBase struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMBase) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepBase)
+}
+
+func (s *SMBase) stepBase(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepHidden)
+}
+
+//smuml:ignore
+func (s *SMBase) stepHidden(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+
+type SMDerived struct {
+	SMBase
+}
+
+func (s *SMDerived) stepOwn(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepHidden)
+}
+`
+	out := renderSource(t, src)
+	if strings.Contains(out, "stepHidden") {
+		t.Errorf("the ignored step is rendered:\n%s", out)
+	}
+}
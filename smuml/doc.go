@@ -0,0 +1,19 @@
+// Package smuml builds diagrams of state machines of the smachine (conveyor) framework from go sources.
+//
+// Sources are added to a FileSet from files or from in-memory buffers, then the FileSet resolves
+// models of SMs and renders them:
+//
+//	fs := smuml.NewFileSet()
+//	if err := fs.AddSource("sm_foo.go", src); err != nil {
+//		return err
+//	}
+//	for _, d := range fs.Resolve() {
+//		for name, step := range d.Steps {
+//			fmt.Println(d.RType, name, len(step.Transitions))
+//		}
+//	}
+//	return fs.Render(os.Stdout, smuml.FormatPlantUML)
+//
+// Tracing options, i.e. SetUsageFilter, SetLabelPolicy, SetAPIVersion and LoadOperations,
+// must be set before adding sources. Sources can't be added after Resolve.
+package smuml
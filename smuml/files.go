@@ -0,0 +1,692 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+type sourceFile struct {
+	fs       *FileSet
+	filename string
+	output   string
+	pkgName  string
+
+	base     token.Pos
+	src      []byte
+	comments ast.CommentMap
+
+	smachinePkg string
+	api         string
+	ops         OpCatalogue
+}
+
+func (p *sourceFile) parseAst(fileAst *ast.File) {
+	for _, imp := range fileAst.Imports {
+		switch pkg, err := strconv.Unquote(imp.Path.Value); {
+		case err != nil:
+			panic(err)
+		case pkg != p.fs.smachinePkg:
+			continue
+		case imp.Name != nil:
+			p.smachinePkg = imp.Name.Name
+		default:
+			p.smachinePkg = p.fs.smachinePkg
+			if n := strings.LastIndexByte(p.smachinePkg, '/'); n >= 0 {
+				p.smachinePkg = p.smachinePkg[n+1:]
+			}
+		}
+		break
+	}
+
+	if p.smachinePkg == "" {
+		return
+	}
+	if fileAst.Name != nil {
+		p.pkgName = fileAst.Name.Name
+	}
+	p.comments = ast.NewCommentMap(p.fs.fs, fileAst, fileAst.Comments)
+	p.parseStepDeclarations(fileAst)
+
+	for _, decl := range fileAst.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok {
+			switch gd.Tok {
+			case token.TYPE:
+				p.parseTypeDecl(gd)
+			case token.VAR:
+				p.parseVarDecl(gd)
+			}
+			continue
+		}
+		if fd, ok := decl.(*ast.FuncDecl); ok {
+			p.parseConstructorDecl(fd)
+			p.parseFieldAssignments(fd)
+			p.parseDeclarationLink(fd)
+			p.parseDeclarationHook(fd)
+
+			md := p.parseFuncDecl(fd)
+			if md == nil {
+				continue
+			}
+
+			md.Doc = commentText(fd.Doc)
+			md.parseFuncBody(fd.Body, p)
+			if !md.applyDirectives(parseDirectives(fd.Doc)) {
+				p.fs.ignoreStep(md.RType, md.Name)
+				continue
+			}
+			p.fs.addStep(p.output, p.pkgName, md)
+		}
+	}
+}
+
+const typeStateUpdate = "StateUpdate"
+const getInitStateForFunc = "GetInitStateFor"
+const getSubroutineInitState = "GetSubroutineInitState"
+const getStateMachineDeclaration = "GetStateMachineDeclaration"
+const getShadowMigrateFor = "GetShadowMigrateFor"
+const getStepLogger = "GetStepLogger"
+const getStepDeclaration = "GetStepDeclaration"
+const isConsecutive = "IsConsecutive"
+const typeStepDeclaration = "StepDeclaration"
+
+func (p *sourceFile) parseFuncDecl(fd *ast.FuncDecl) *MethodDecl {
+	if fd.Type.Results == nil {
+		return p.parseErrorHandlerDecl(fd)
+	}
+
+	md := p.findStateUpdate(fd.Type.Results.List)
+
+	checkContext := false
+	switch {
+	case md != nil:
+		checkContext = true
+		if fd.Name != nil {
+			md.Name = fd.Name.Name
+		}
+	case fd.Name == nil:
+		return nil
+	default:
+		switch fd.Name.Name {
+		case getInitStateForFunc, getSubroutineInitState:
+			md = p.findFuncWith(fd.Type.Results.List, fd.Name.Name, "InitFunc")
+		}
+		if md == nil {
+			return nil
+		}
+	}
+
+	if !p.parseReceiver(fd, md) {
+		return nil
+	}
+
+	if checkContext && fd.Type.Params != nil {
+		md.MType, md.CtxArg = p.findContextArg(fd.Type.Params.List)
+	}
+
+	return md
+}
+
+func (p *sourceFile) parseReceiver(fd *ast.FuncDecl, md *MethodDecl) bool {
+	if fd.Recv == nil {
+		return true
+	}
+	if len(fd.Recv.List) != 1 {
+		return false
+	}
+	id := fd.Recv.List[0]
+	switch len(id.Names) {
+	case 0:
+	case 1:
+		md.RName = id.Names[0].Name
+	default:
+		// wtf?
+		return false
+	}
+
+	switch x, sel, _ := getTypeOfExpr(id.Type); {
+	case sel == "":
+		return false
+	case x != "":
+		return false
+	default:
+		md.RType = sel
+		md.TypeParams = getTypeParamsOfExpr(id.Type)
+	}
+	return true
+}
+
+// parseErrorHandlerDecl handles a method that can be used as ErrorHandler, e.g. func (s *SM) onError(ctx FailureContext)
+func (p *sourceFile) parseErrorHandlerDecl(fd *ast.FuncDecl) *MethodDecl {
+	if fd.Name == nil || fd.Type.Params == nil {
+		return nil
+	}
+	if mt, _ := p.findContextArg(fd.Type.Params.List); mt != Failure {
+		return nil
+	}
+
+	md := &MethodDecl{Name: fd.Name.Name}
+	if !p.parseReceiver(fd, md) {
+		return nil
+	}
+	md.MType, md.CtxArg = p.findContextArg(fd.Type.Params.List)
+	return md
+}
+
+// parseConstructorDecl registers a package-level func that returns a single (pointer to) local type,
+// it is used to resolve a type of a child SM created by NewChild(func(...) { return NewSMxxx() })
+func (p *sourceFile) parseConstructorDecl(fd *ast.FuncDecl) {
+	switch {
+	case fd.Recv != nil:
+		return
+	case fd.Name == nil:
+		return
+	case fd.Type.Results == nil || len(fd.Type.Results.List) != 1:
+		return
+	}
+
+	switch x, sel := getSelectorOfExpr(unindexExpr(unstarExpr(fd.Type.Results.List[0].Type))); {
+	case x != "":
+	case sel == "":
+	default:
+		p.fs.addConstructor(fd.Name.Name, sel)
+	}
+}
+
+// parseTypeDecl collects embedded structs of local types, to include promoted steps
+func (p *sourceFile) parseTypeDecl(gd *ast.GenDecl) {
+	for _, spec := range gd.Specs {
+		ts, ok := spec.(*ast.TypeSpec)
+		if !ok || ts.Name == nil {
+			continue
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok || st.Fields == nil {
+			continue
+		}
+		for _, field := range st.Fields.List {
+			if len(field.Names) != 0 {
+				continue
+			}
+			if x, sel := getSelectorOfExpr(unindexExpr(unstarExpr(field.Type))); x == "" && sel != "" {
+				p.fs.addEmbedded(p.output, p.pkgName, ts.Name.Name, sel)
+			}
+		}
+	}
+}
+
+// parseVarDecl collects package vars initialized with a local type, e.g. var declSMFoo = &dSMFoo{}
+func (p *sourceFile) parseVarDecl(gd *ast.GenDecl) {
+	for _, spec := range gd.Specs {
+		vs, ok := spec.(*ast.ValueSpec)
+		if !ok || len(vs.Names) != len(vs.Values) {
+			continue
+		}
+		for i, name := range vs.Names {
+			if typeName := getLiteralTypeOfExpr(vs.Values[i]); typeName != "" {
+				p.fs.addDeclarationVar(name.Name, typeName)
+			}
+		}
+	}
+}
+
+// parseDeclarationLink pairs a declaration type with its SM type either by a cast in GetInitStateFor,
+// e.g. return sm.(*SMFoo).Init, or by a result of GetStateMachineDeclaration of the SM
+func (p *sourceFile) parseDeclarationLink(fd *ast.FuncDecl) {
+	if fd.Name == nil || fd.Body == nil {
+		return
+	}
+	md := &MethodDecl{}
+	if fd.Recv == nil || !p.parseReceiver(fd, md) {
+		return
+	}
+
+	switch fd.Name.Name {
+	case getInitStateForFunc:
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			if ta, ok := n.(*ast.TypeAssertExpr); ok && ta.Type != nil {
+				if x, sel := getSelectorOfExpr(unindexExpr(unstarExpr(ta.Type))); x == "" && sel != "" {
+					p.fs.addDeclaration(md.RType, sel)
+					return false
+				}
+			}
+			return true
+		})
+	case getStateMachineDeclaration:
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			ret, ok := n.(*ast.ReturnStmt)
+			if !ok || len(ret.Results) != 1 {
+				return true
+			}
+			switch result := ret.Results[0].(type) {
+			case *ast.Ident:
+				p.fs.addDeclarationRef(md.RType, result.Name)
+			default:
+				if typeName := getLiteralTypeOfExpr(result); typeName != "" {
+					p.fs.addDeclaration(typeName, md.RType)
+				}
+			}
+			return false
+		})
+	}
+}
+
+// parseDeclarationHook registers an optional method of a declaration type, e.g. GetShadowMigrateFor
+func (p *sourceFile) parseDeclarationHook(fd *ast.FuncDecl) {
+	if fd.Name == nil || fd.Body == nil {
+		return
+	}
+	switch fd.Name.Name {
+	case getShadowMigrateFor, getStepLogger, getStepDeclaration, isConsecutive:
+	default:
+		return
+	}
+	md := &MethodDecl{}
+	if fd.Recv == nil || !p.parseReceiver(fd, md) {
+		return
+	}
+
+	// the first result that enables the hook, e.g. `return nil, false` of GetStepLogger doesn't
+	result := ""
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		switch op := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if len(op.Results) == 0 {
+				break
+			}
+			switch s := types.ExprString(op.Results[0]); s {
+			case "nil", "false":
+			default:
+				result = s
+			}
+		}
+		return result == ""
+	})
+	if result == "" {
+		return
+	}
+
+	hooks := p.fs.declHooksOf(md.RType)
+	switch fd.Name.Name {
+	case getShadowMigrateFor:
+		// return sm.(*SMFoo).shadowMigrate
+		if n := strings.LastIndexByte(result, '.'); n >= 0 {
+			result = result[n+1:]
+		}
+		hooks.ShadowMigrate = result
+	case getStepLogger:
+		hooks.StepLogger = result
+	case getStepDeclaration:
+		hooks.StepDeclaration = result
+	case isConsecutive:
+		hooks.IsConsecutive = true
+	}
+}
+
+// parseStepDeclarations collects names of steps given by StepDeclaration literals, e.g.
+// smachine.StepDeclaration{SlotStep: smachine.SlotStep{Transition: (*SMFoo).stepWait}, Name: "waiting"}
+func (p *sourceFile) parseStepDeclarations(fileAst *ast.File) {
+	ast.Inspect(fileAst, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		switch t := lit.Type.(type) {
+		case *ast.ArrayType:
+			if p.isStepDeclarationType(t.Elt) {
+				for _, elt := range lit.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						elt = kv.Value
+					}
+					if decl, ok := unstarExpr(elt).(*ast.CompositeLit); ok {
+						p.parseStepDeclaration(decl)
+					}
+				}
+				return false
+			}
+		case *ast.MapType:
+			if p.isStepDeclarationType(t.Value) {
+				for _, elt := range lit.Elts {
+					if kv, ok := elt.(*ast.KeyValueExpr); ok {
+						if decl, ok := kv.Value.(*ast.CompositeLit); ok {
+							p.parseStepDeclaration(decl)
+						}
+					}
+				}
+				return false
+			}
+		default:
+			if p.isStepDeclarationType(lit.Type) {
+				p.parseStepDeclaration(lit)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (p *sourceFile) isStepDeclarationType(expr ast.Expr) bool {
+	x, sel := getSelectorOfExpr(unstarExpr(expr))
+	return x == p.smachinePkg && sel == typeStepDeclaration
+}
+
+func (p *sourceFile) parseStepDeclaration(lit *ast.CompositeLit) {
+	name := ""
+	var transition ast.Expr
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		switch _, key := getSelectorOfExpr(kv.Key); key {
+		case "Name":
+			if bl, ok := kv.Value.(*ast.BasicLit); ok && bl.Kind == token.STRING {
+				name, _ = strconv.Unquote(bl.Value)
+			}
+		case "SlotStep":
+			if step, ok := kv.Value.(*ast.CompositeLit); ok {
+				transition = getSlotStepTransition(step)
+			}
+		}
+	}
+	if name == "" || transition == nil {
+		return
+	}
+
+	// (*SMFoo).stepWait or (&SMFoo{}).stepWait give a type of SM, s.stepWait gives only a name of the step
+	sel, ok := transition.(*ast.SelectorExpr)
+	if !ok || sel.Sel == nil {
+		return
+	}
+	p.fs.addStepName(p.output, receiverTypeOf(sel.X), sel.Sel.Name, name)
+}
+
+// receiverTypeOf returns a type of a receiver given by a method expression or by a value of the type,
+// e.g. SMFoo of (*SMFoo), (&SMFoo{}) or new(SMFoo), and returns empty for a variable
+func receiverTypeOf(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.ParenExpr:
+		return receiverTypeOf(x.X)
+	case *ast.UnaryExpr:
+		if x.Op == token.AND {
+			return receiverTypeOf(x.X)
+		}
+	case *ast.StarExpr:
+		return typeNameOf(x.X)
+	case *ast.CompositeLit:
+		return typeNameOf(x.Type)
+	case *ast.CallExpr:
+		if id, ok := x.Fun.(*ast.Ident); ok && id.Name == "new" && len(x.Args) == 1 {
+			return typeNameOf(x.Args[0])
+		}
+	}
+	return ""
+}
+
+// typeNameOf returns a name of a type declared in the package, e.g. SMFoo of SMFoo[T]
+func typeNameOf(expr ast.Expr) string {
+	if x, t := getSelectorOfExpr(unindexExpr(expr)); x == "" {
+		return t
+	}
+	return ""
+}
+
+func getSlotStepTransition(lit *ast.CompositeLit) ast.Expr {
+	for i, elt := range lit.Elts {
+		switch kv, ok := elt.(*ast.KeyValueExpr); {
+		case !ok:
+			if i == 0 {
+				return elt
+			}
+		default:
+			if _, key := getSelectorOfExpr(kv.Key); key == "Transition" {
+				return kv.Value
+			}
+		}
+	}
+	return nil
+}
+
+// parseFieldAssignments collects method values assigned to fields of the receiver, e.g. s.onDone = s.stepFinish
+func (p *sourceFile) parseFieldAssignments(fd *ast.FuncDecl) {
+	if fd.Body == nil {
+		return
+	}
+	md := &MethodDecl{}
+	if fd.Recv == nil || !p.parseReceiver(fd, md) || md.RName == "" {
+		return
+	}
+
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		op, ok := n.(*ast.AssignStmt)
+		if !ok || len(op.Lhs) != len(op.Rhs) {
+			return true
+		}
+		for i, lhs := range op.Lhs {
+			lx, field := getSelectorOfExpr(lhs)
+			if lx != md.RName || field == "" {
+				continue
+			}
+			if rx, method := getSelectorOfExpr(op.Rhs[i]); rx == md.RName && method != "" {
+				p.fs.addFieldValue(md.RType, field, method)
+			}
+		}
+		return true
+	})
+}
+
+func (p *sourceFile) findStateUpdate(retFields []*ast.Field) *MethodDecl {
+	md := MethodDecl{}
+
+	md.UpdateIdx, md.UpdateArg = p.findResultArg(retFields, typeStateUpdate, false)
+	if md.UpdateIdx == 0 {
+		return nil
+	}
+
+	return &md
+}
+
+func (p *sourceFile) findFuncWith(retFields []*ast.Field, funcName, resTypeName string) *MethodDecl {
+	md := MethodDecl{}
+
+	if idx, _ := p.findResultArg(retFields, resTypeName, true); idx == 0 {
+		return nil
+	}
+
+	md.Name = funcName
+	md.MType = DeclarationInit
+	return &md
+}
+
+func (p *sourceFile) findResultArg(retFields []*ast.Field, typeName string, onlyOne bool) (retPos int, retName string) {
+	argPos := 0
+	for _, retArg := range retFields {
+		switch n := len(retArg.Names); n {
+		case 0, 1:
+			//
+		default:
+			if onlyOne {
+				return 0, ""
+			}
+			argPos += n
+			continue
+		}
+		if onlyOne && argPos > 0 {
+			return 0, ""
+		}
+		argPos++
+
+		switch x, sel := getSelectorOfExpr(retArg.Type); {
+		case sel != typeName:
+			continue
+		case x != p.smachinePkg:
+			continue
+		}
+
+		if retPos > 0 {
+			return 0, ""
+		}
+		retPos = argPos
+		if len(retArg.Names) > 0 {
+			retName = retArg.Names[0].Name
+		}
+	}
+
+	return
+}
+
+func (p *sourceFile) findContextArg(params []*ast.Field) (MethodType, string) {
+	for _, inArg := range params {
+		x, sel := getSelectorOfExpr(inArg.Type)
+		if x != p.smachinePkg {
+			continue
+		}
+
+		argName := ""
+		if len(inArg.Names) > 0 {
+			argName = inArg.Names[0].Name
+		}
+
+		switch sel {
+		case "InitializationContext":
+			return Initialization, argName
+		case "ExecutionContext":
+			return Execution, argName
+		case "MigrationContext":
+			return Migration, argName
+		case "ConstructionContext":
+			return Construction, argName
+		case "BargeInContext":
+			return BargeIn, argName
+		case "FailureContext":
+			return Failure, argName
+		}
+	}
+
+	return 0, ""
+}
+
+// commentOf returns the first line of a comment attached to the node, e.g. placed right above it
+func (p *sourceFile) commentOf(node ast.Node) string {
+	if node == nil || p.comments == nil {
+		return ""
+	}
+	groups := p.comments[node]
+	for i := len(groups) - 1; i >= 0; i-- {
+		if s := commentText(groups[i]); s != "" {
+			return firstLine(s)
+		}
+	}
+	return ""
+}
+
+func commentText(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+	return strings.TrimSpace(cg.Text())
+}
+
+func firstLine(s string) string {
+	if n := strings.IndexByte(s, '\n'); n >= 0 {
+		s = s[:n]
+	}
+	return strings.TrimSpace(s)
+}
+
+// excerpt returns the source text, the text longer than maxLen is cut and ends with ...
+func (p *sourceFile) excerpt(pos token.Pos, end token.Pos, maxLen int) string {
+	pos -= p.base
+	end -= p.base
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	if int(end-pos) > maxLen {
+		return string(p.src[pos:pos+token.Pos(maxLen)]) + `...`
+	}
+	return string(p.src[pos:end])
+}
+
+func getTypeOfExpr(expr ast.Expr) (x, sel string, star bool) {
+	switch arg := expr.(type) {
+	case *ast.StarExpr:
+		star = true
+		expr = arg.X
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+	default:
+		return "", "", false
+	}
+	x, sel = getSelectorOfExpr(unindexExpr(expr))
+	return
+}
+
+// getTypeParamsOfExpr returns names of type params of a generic receiver, e.g. T, K for *SM[T, K]
+func getTypeParamsOfExpr(expr ast.Expr) []string {
+	var indices []ast.Expr
+	switch arg := unstarExpr(expr).(type) {
+	case *ast.IndexExpr:
+		indices = []ast.Expr{arg.Index}
+	case *ast.IndexListExpr:
+		indices = arg.Indices
+	default:
+		return nil
+	}
+
+	params := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		params = append(params, types.ExprString(idx))
+	}
+	return params
+}
+
+// getLiteralTypeOfExpr returns a local type of a composite literal, e.g. dSMFoo for &dSMFoo{}
+func getLiteralTypeOfExpr(expr ast.Expr) string {
+	if op, ok := expr.(*ast.UnaryExpr); ok && op.Op == token.AND {
+		expr = op.X
+	}
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok || lit.Type == nil {
+		return ""
+	}
+	if x, sel := getSelectorOfExpr(unindexExpr(lit.Type)); x == "" {
+		return sel
+	}
+	return ""
+}
+
+func unstarExpr(expr ast.Expr) ast.Expr {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		return star.X
+	}
+	return expr
+}
+
+// unindexExpr strips type arguments of a generic type, e.g. SM[T] to SM
+func unindexExpr(expr ast.Expr) ast.Expr {
+	switch arg := expr.(type) {
+	case *ast.IndexExpr:
+		return arg.X
+	case *ast.IndexListExpr:
+		return arg.X
+	}
+	return expr
+}
+
+func getSelectorOfExpr(expr ast.Expr) (x, sel string) {
+	switch arg := expr.(type) {
+	case *ast.SelectorExpr:
+		if arg.Sel != nil {
+			sel = arg.Sel.Name
+		}
+		if id, ok := arg.X.(*ast.Ident); ok {
+			x = id.Name
+		}
+	case *ast.Ident:
+		return "", arg.Name
+	}
+	return
+}
@@ -0,0 +1,142 @@
+package smuml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeclarationHooks(t *testing.T) {
+	const src = `
+type SMHooks struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMHooks) GetShadowMigrateFor(sm smachine.StateMachine) smachine.ShadowMigrateFunc {
+	return s.shadow
+}
+
+func (s *SMHooks) GetStepLogger(ctx context.Context, sm smachine.StateMachine, tracer smachine.TracerID, factory smachine.LoggerFactory) (smachine.StepLogger, bool) {
+	return nil, false
+}
+
+func (s *SMHooks) IsConsecutive(cur, next smachine.StateFunc) bool {
+	return false
+}
+
+func (s *SMHooks) shadow(mc smachine.MigrationContext, stable bool) {
+}
+
+func (s *SMHooks) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepA)
+}
+
+func (s *SMHooks) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepB)
+}
+
+func (s *SMHooks) stepB(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	hooks := resolveSM(t, src, "SMHooks").Hooks
+	if hooks == nil || hooks.ShadowMigrate != "shadow" || hooks.StepLogger != "" || hooks.IsConsecutive {
+		t.Fatalf("unexpected hooks: %+v", hooks)
+	}
+
+	requireEdges(t, renderEdges(t, src),
+		"stepA : <size:10>shadow migration: shadow</size>",
+		"stepB : <size:10>shadow migration: shadow</size>",
+	)
+}
+
+func TestStepNames(t *testing.T) {
+	const src = `
+type SMA struct{ smachine.StateMachineDeclTemplate }
+
+type SMB struct{ smachine.StateMachineDeclTemplate }
+
+var s = &SMA{}
+
+var declarations = []smachine.StepDeclaration{
+	{SlotStep: smachine.SlotStep{Transition: (&SMA{}).stepWork}, Name: "working A"},
+	{SlotStep: smachine.SlotStep{Transition: (*SMB).stepDone}, Name: "done B"},
+	{SlotStep: smachine.SlotStep{Transition: s.stepOnlyA}, Name: "only A"},
+	{SlotStep: smachine.SlotStep{Transition: s.stepDone}, Name: "ambiguous"},
+}
+
+func (s *SMA) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMA) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepOnlyA)
+}
+
+func (s *SMA) stepOnlyA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepDone)
+}
+
+func (s *SMA) stepDone(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+
+func (s *SMB) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMB) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepDone)
+}
+
+func (s *SMB) stepDone(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	fs := newTestFileSet(t, src)
+	decls := fs.Resolve()
+	if len(decls) != 2 {
+		t.Fatalf("unexpected SMs: %+v", decls)
+	}
+	for _, tc := range []struct {
+		sm   *SMDecl
+		want map[string]string
+	}{
+		{decls[0], map[string]string{"stepWork": "working A", "stepOnlyA": "only A", "stepDone": ""}},
+		{decls[1], map[string]string{"stepWork": "", "stepDone": "done B"}},
+	} {
+		for step, want := range tc.want {
+			if got := tc.sm.Steps[step].DeclName; got != want {
+				t.Errorf("%s.%s: got %q, want %q", tc.sm.RType, step, got, want)
+			}
+		}
+	}
+}
+
+func TestGenericReceiver(t *testing.T) {
+	const src = `
+type SMGen[T any, K comparable] struct {
+	smachine.StateMachineDeclTemplate
+	v T
+	k K
+}
+
+func (s *SMGen[T, K]) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMGen[_, K]) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	sm := resolveSM(t, src, "SMGen")
+	if len(sm.Steps) != 2 || sm.Title() != "SMGen[T, K]" {
+		t.Fatalf("unexpected SM %s with steps %v", sm.Title(), sm.Steps)
+	}
+
+	out := renderSource(t, src)
+	if !strings.Contains(out, "\ntitle SMGen[T, K]\n") {
+		t.Errorf("no title in:\n%s", out)
+	}
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepWork",
+		"stepWork : SMGen[T, K]",
+	)
+}
@@ -0,0 +1,623 @@
+package smuml
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NewFileSet returns a FileSet for the smachine package of assured-ledger with PlantUML output
+func NewFileSet() *FileSet {
+	return &FileSet{
+		fs:           token.NewFileSet(),
+		umlExtension: ".plantuml",
+		smachinePkg:  `github.com/insolar/assured-ledger/ledger-core/conveyor/smachine`,
+		labels:       DefaultLabelPolicy(),
+	}
+}
+
+// FileSet collects SMs from go sources and writes their diagrams
+type FileSet struct {
+	fs    *token.FileSet
+	files map[token.Pos]*sourceFile
+
+	umlExtension string
+	smachinePkg  string
+
+	types        map[string]*SMDecl
+	constructors map[string]string
+	fieldValues  map[string]map[string][]string
+	embedded     map[string][]string
+	embedders    map[string]*SMDecl // types with embedded structs, to be added when they embed an SM
+	declTypes    map[string]string  // declaration type -> SM type
+	declVars     map[string]string  // package var -> declaration type
+	declRefs     map[string]string  // SM type -> package var returned by GetStateMachineDeclaration
+	declHooks    map[string]*DeclHooks
+	stepNames    map[string]map[string]string // SM type -> step -> a name given by StepDeclaration
+	fileNames    map[string]map[string]string // output -> step -> a name given for an unknown SM type
+	ignored      map[string][]string          // SM type -> steps hidden by //smuml:ignore
+
+	usageFilter map[string]bool
+	labels      LabelPolicy
+
+	api        string                 // a forced API version, otherwise it is detected per module
+	userOps    OpCatalogue            // overrides loaded by LoadOperations
+	catalogues map[string]OpCatalogue // API version -> operations
+	moduleAPIs map[string]APIVersion  // go.mod -> API version
+	warned     map[string]bool
+	warnings   []string
+	resolved   bool
+}
+
+// AddFile parses a go file and collects SMs declared by it
+func (p *FileSet) AddFile(filename string) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filename, err)
+	}
+	return p.AddSource(filename, src)
+}
+
+// AddReader reads a source text, e.g. from stdin or an unsaved buffer of an editor, and adds it by AddSource
+func (p *FileSet) AddReader(filename string, r io.Reader) error {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read source of %s: %w", filename, err)
+	}
+	return p.AddSource(filename, src)
+}
+
+// AddSource parses a source text of a go file, the filename is used for positions, for output names and
+// to detect a version of smachine API by the framework source used by its module.
+// Sources can't be added after Resolve.
+func (p *FileSet) AddSource(filename string, src []byte) error {
+	if p.resolved {
+		return fmt.Errorf("failed to add file %s: SMs are already resolved", filename)
+	}
+	base := p.fs.Base()
+	fileAst, err := parser.ParseFile(p.fs, filename, src, parser.ParseComments)
+	if err != nil {
+		return fmt.Errorf("failed to parse file %s: %w", filename, err)
+	}
+
+	output := filename
+	if ext := filepath.Ext(output); ext != "" {
+		output = output[:len(output)-len(ext)]
+	}
+	api := p.apiOf(filename)
+	fileInfo := sourceFile{fs: p, filename: filename, output: output, src: src, base: token.Pos(base), api: api.Name, ops: p.opsOf(api)}
+	fileInfo.parseAst(fileAst)
+	fileInfo.src = nil
+	return nil
+}
+
+func (p *FileSet) addStep(output, pkgName string, md *MethodDecl) {
+	if p.types == nil {
+		p.types = map[string]*SMDecl{}
+	}
+	rt := p.types[md.RType]
+	if rt == nil {
+		rt = &SMDecl{RType: md.RType, Output: output, Package: pkgName, SeqNo: len(p.types)}
+		p.types[rt.RType] = rt
+	}
+	if rt.TypeParams == nil {
+		rt.TypeParams = md.TypeParams
+	}
+	rt.addStep(md, false)
+}
+
+// SetUsageFilter sets comma separated categories of context calls to be shown on steps,
+// "all" shows every category and "none" or an empty string hides usages.
+func (p *FileSet) SetUsageFilter(categories string) {
+	if categories == "all" {
+		p.usageFilter = nil
+		return
+	}
+	p.usageFilter = map[string]bool{}
+	for _, c := range strings.Split(categories, ",") {
+		if c = strings.TrimSpace(c); c != "" && c != "none" {
+			p.usageFilter[c] = true
+		}
+	}
+}
+
+// LabelPolicy returns the label policy, see SetLabelPolicy
+func (p *FileSet) LabelPolicy() LabelPolicy {
+	return p.labels
+}
+
+// LoadOperations overrides the catalogue of smachine operations by a JSON file for all API versions,
+// it must be called before adding files
+func (p *FileSet) LoadOperations(path string) error {
+	if p.userOps == nil {
+		p.userOps = OpCatalogue{}
+	}
+	if err := p.userOps.LoadFile(path); err != nil {
+		return err
+	}
+	p.catalogues = nil
+	return nil
+}
+
+// SetAPIVersion forces a version of smachine API for all files, it must be called before adding files.
+// An empty name restores detection of the version by the framework source used by each module.
+func (p *FileSet) SetAPIVersion(name string) error {
+	if name != "" {
+		if _, err := FindAPIVersion(name); err != nil {
+			return err
+		}
+	}
+	p.api = name
+	return nil
+}
+
+// apiOf returns a version of smachine API for the file, by methods declared by the framework source used by its go.mod
+func (p *FileSet) apiOf(filename string) APIVersion {
+	if p.api != "" {
+		api, _ := FindAPIVersion(p.api)
+		return api
+	}
+
+	modFile := findModuleFile(filename)
+	if api, ok := p.moduleAPIs[modFile]; ok {
+		return api
+	}
+	api := DetectAPIVersion(declaredMethods(packageDir(modFile, p.smachinePkg)))
+	if p.moduleAPIs == nil {
+		p.moduleAPIs = map[string]APIVersion{}
+	}
+	p.moduleAPIs[modFile] = api
+	return api
+}
+
+// opsOf returns operations of the API version with overrides of LoadOperations
+func (p *FileSet) opsOf(api APIVersion) OpCatalogue {
+	if ops := p.catalogues[api.Name]; ops != nil {
+		return ops
+	}
+	ops := DefaultOpCatalogue()
+	for name, spec := range api.Ops {
+		ops[name] = spec
+	}
+	for name, spec := range p.userOps {
+		ops[name] = spec
+	}
+	if p.catalogues == nil {
+		p.catalogues = map[string]OpCatalogue{}
+	}
+	p.catalogues[api.Name] = ops
+	return ops
+}
+
+// warn records a warning once, see Warnings
+func (p *FileSet) warn(msg string) {
+	if p.warned[msg] {
+		return
+	}
+	if p.warned == nil {
+		p.warned = map[string]bool{}
+	}
+	p.warned[msg] = true
+	p.warnings = append(p.warnings, msg)
+}
+
+// Warnings returns warnings found by tracing of the added files, e.g. usages of deprecated operations, in order of appearance
+func (p *FileSet) Warnings() []string {
+	return p.warnings
+}
+
+// SetLabelPolicy must be called before adding files, as limits are applied while tracing
+func (p *FileSet) SetLabelPolicy(labels LabelPolicy) {
+	p.labels = labels
+}
+
+func (p *FileSet) addConstructor(funcName, typeName string) {
+	if p.constructors == nil {
+		p.constructors = map[string]string{}
+	}
+	p.constructors[funcName] = typeName
+}
+
+// addDeclaration pairs a declaration type, e.g. dSMFoo, with its SM type
+func (p *FileSet) addDeclaration(declType, smType string) {
+	if p.declTypes == nil {
+		p.declTypes = map[string]string{}
+	}
+	p.declTypes[declType] = smType
+}
+
+// addDeclarationVar registers a package var initialized with a declaration type, e.g. var declSMFoo = &dSMFoo{}
+func (p *FileSet) addDeclarationVar(varName, declType string) {
+	if p.declVars == nil {
+		p.declVars = map[string]string{}
+	}
+	p.declVars[varName] = declType
+}
+
+// addDeclarationRef registers a package var returned by GetStateMachineDeclaration of the SM type
+func (p *FileSet) addDeclarationRef(smType, varName string) {
+	if p.declRefs == nil {
+		p.declRefs = map[string]string{}
+	}
+	p.declRefs[smType] = varName
+}
+
+// addStepName registers a name of the step given by StepDeclaration, the type is empty when unknown
+func (p *FileSet) addStepName(output, typeName, stepName, name string) {
+	names := &p.stepNames
+	if typeName == "" {
+		names, typeName = &p.fileNames, output
+	}
+	if *names == nil {
+		*names = map[string]map[string]string{}
+	}
+	if (*names)[typeName] == nil {
+		(*names)[typeName] = map[string]string{}
+	}
+	(*names)[typeName][stepName] = name
+}
+
+// resolveStepNames gives a name of a step of an unknown SM type to the SM of the same file that has
+// the step, the name is dropped when several SMs of the file have the step
+func (p *FileSet) resolveStepNames(decls []*SMDecl) {
+	for output, names := range p.fileNames {
+		for stepName, name := range names {
+			var owner *SMDecl
+			for _, d := range decls {
+				if step := d.Steps[stepName]; d.Output != output || step == nil || step.InheritedFrom != "" {
+					continue
+				}
+				if owner != nil {
+					owner = nil
+					break
+				}
+				owner = d
+			}
+			if owner != nil && p.stepNames[owner.RType][stepName] == "" {
+				p.addStepName(output, owner.RType, stepName, name)
+			}
+		}
+	}
+}
+
+// declHooksOf returns hooks declared by methods of the given type
+func (p *FileSet) declHooksOf(typeName string) *DeclHooks {
+	if p.declHooks == nil {
+		p.declHooks = map[string]*DeclHooks{}
+	}
+	hooks := p.declHooks[typeName]
+	if hooks == nil {
+		hooks = &DeclHooks{}
+		p.declHooks[typeName] = hooks
+	}
+	return hooks
+}
+
+// resolveDeclarations merges steps of declaration types into their SM types
+func (p *FileSet) resolveDeclarations() {
+	for smType, varName := range p.declRefs {
+		if declType := p.declVars[varName]; declType != "" {
+			p.addDeclaration(declType, smType)
+		}
+	}
+
+	for declType, smType := range p.declTypes {
+		dd, sd := p.types[declType], p.types[smType]
+		if dd == nil || sd == nil || dd == sd {
+			continue
+		}
+		sd.mergeDeclaration(dd)
+		delete(p.types, declType)
+	}
+
+	for _, d := range p.types {
+		if d.Hooks = p.declHooks[d.DeclType]; d.Hooks == nil {
+			d.Hooks = p.declHooks[d.RType]
+		}
+	}
+}
+
+// addFieldValue registers a method value assigned to a field of the given type
+func (p *FileSet) addFieldValue(typeName, field, method string) {
+	if p.fieldValues == nil {
+		p.fieldValues = map[string]map[string][]string{}
+	}
+	fields := p.fieldValues[typeName]
+	if fields == nil {
+		fields = map[string][]string{}
+		p.fieldValues[typeName] = fields
+	}
+	for _, m := range fields[field] {
+		if m == method {
+			return
+		}
+	}
+	fields[field] = append(fields[field], method)
+}
+
+// ignoreStep registers a step hidden by //smuml:ignore, transitions to it are removed by Resolve
+func (p *FileSet) ignoreStep(typeName, stepName string) {
+	if p.ignored == nil {
+		p.ignored = map[string][]string{}
+	}
+	p.ignored[typeName] = append(p.ignored[typeName], stepName)
+}
+
+// removeIgnored removes transitions to steps hidden in the type or in its embedded types
+func (p *FileSet) removeIgnored(d *SMDecl, typeName string) {
+	for _, name := range p.ignored[typeName] {
+		for _, step := range d.Steps {
+			step.removeTransitionsTo(name)
+		}
+	}
+	for _, baseType := range p.embedded[typeName] {
+		p.removeIgnored(d, baseType)
+	}
+}
+
+func (p *FileSet) addEmbedded(output, pkgName, typeName, embeddedType string) {
+	if p.embedded == nil {
+		p.embedded = map[string][]string{}
+		p.embedders = map[string]*SMDecl{}
+	}
+	p.embedded[typeName] = append(p.embedded[typeName], embeddedType)
+	if p.embedders[typeName] == nil {
+		p.embedders[typeName] = &SMDecl{RType: typeName, Output: output, Package: pkgName}
+	}
+}
+
+// addEmbeddingSMs adds SMs that have no steps of their own, but embed another SM
+func (p *FileSet) addEmbeddingSMs() {
+	typeNames := make([]string, 0, len(p.embedders))
+	for typeName := range p.embedders {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	for _, typeName := range typeNames {
+		if p.types[typeName] == nil && p.embedsSM(typeName, map[string]bool{}) {
+			d := p.embedders[typeName]
+			d.SeqNo = len(p.types)
+			p.types[typeName] = d
+		}
+	}
+}
+
+func (p *FileSet) embedsSM(typeName string, visited map[string]bool) bool {
+	if visited[typeName] {
+		return false
+	}
+	visited[typeName] = true
+	for _, baseType := range p.embedded[typeName] {
+		if p.types[baseType] != nil || p.embedsSM(baseType, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveEmbedded adds steps promoted from embedded SMs, bases are resolved first
+func (p *FileSet) resolveEmbedded(d *SMDecl) {
+	if d.embedResolved {
+		return
+	}
+	d.embedResolved = true
+
+	for _, baseType := range p.embedded[d.RType] {
+		base := p.types[baseType]
+		if base == nil {
+			continue
+		}
+		p.resolveEmbedded(base)
+		d.inherit(base)
+	}
+}
+
+// collectFieldValues returns method values assigned to fields of the type and of its embedded types
+func (p *FileSet) collectFieldValues(typeName string, fields map[string][]string) map[string][]string {
+	for field, values := range p.fieldValues[typeName] {
+		if fields == nil {
+			fields = map[string][]string{}
+		}
+		fields[field] = append(fields[field], values...)
+	}
+	for _, baseType := range p.embedded[typeName] {
+		fields = p.collectFieldValues(baseType, fields)
+	}
+	return fields
+}
+
+func (p *FileSet) resolveChildren(d *SMDecl) {
+	for _, step := range d.Steps {
+		for i := range step.Children {
+			ch := &step.Children[i]
+			if ch.Child == "" && ch.Constructor != "" {
+				ch.Child = p.constructors[ch.Constructor]
+			}
+			ch.ChildTo = p.types[ch.Child]
+		}
+	}
+}
+
+// Resolve links steps of the added files: merges declaration types, adds steps of embedded SMs,
+// applies step names, resolves children and fields, and propagates defaults by transitions.
+// It returns SMs ordered by output and type, files added after it are not resolved.
+func (p *FileSet) Resolve() []*SMDecl {
+	if !p.resolved {
+		p.resolved = true
+		p.resolveDeclarations()
+		p.addEmbeddingSMs()
+		decls := p.sortedDecls()
+		// steps are inherited before any propagation, so inherited copies don't depend on the order of SMs
+		for _, d := range decls {
+			p.resolveEmbedded(d)
+		}
+		p.resolveStepNames(decls)
+		for _, d := range decls {
+			p.removeIgnored(d, d.RType)
+			d.applyStepNames(p.stepNames)
+			p.resolveChildren(d)
+			d.resolveFields(p.collectFieldValues(d.RType, nil))
+			d.propagate()
+		}
+	}
+	return p.sortedDecls()
+}
+
+func (p *FileSet) sortedDecls() []*SMDecl {
+	decls := make([]*SMDecl, 0, len(p.types))
+	for _, d := range p.types {
+		decls = append(decls, d)
+	}
+	sort.SliceStable(decls, func(i, j int) bool {
+		if n := strings.Compare(decls[i].Output, decls[j].Output); n != 0 {
+			return n < 0
+		}
+		return strings.Compare(decls[i].RType, decls[j].RType) < 0
+	})
+	return decls
+}
+
+// WriteUMLs writes diagrams into files next to sources, one per source file, or to stdout when console is set
+func (p *FileSet) WriteUMLs(console bool) error {
+	decls := p.Resolve()
+	if len(decls) == 0 {
+		return nil
+	}
+	singleFile := ""
+	if console {
+		singleFile = "-"
+	}
+	if err := p.writePagedUML(singleFile, decls); err != nil {
+		return err
+	}
+	return p.writeSyncReports(singleFile)
+}
+
+// Render writes diagrams of all SMs and reports of sync objects in the given format
+func (p *FileSet) Render(out io.Writer, format Format) error {
+	if format != FormatPlantUML {
+		return fmt.Errorf("unsupported output format: %q", format)
+	}
+	decls := p.Resolve()
+	if len(decls) == 0 {
+		return nil
+	}
+	packages := p.syncReportGroups()
+	return p.writeTo(out, "", func(w *umlWriter) {
+		w.writeUML(decls)
+		for _, pkgOutput := range sortedOutputs(packages) {
+			w.writeSyncReport(packages[pkgOutput])
+		}
+	})
+}
+
+// syncReportGroups returns SMs with sync objects grouped by package, keyed by an output name of the report
+func (p *FileSet) syncReportGroups() map[string][]*SMDecl {
+	packages := map[string][]*SMDecl{}
+	for _, d := range p.types {
+		if !d.HasSyncOps() {
+			continue
+		}
+		pkgOutput := filepath.Join(filepath.Dir(d.Output), d.Package+"_sync")
+		packages[pkgOutput] = append(packages[pkgOutput], d)
+	}
+	for _, decls := range packages {
+		sort.Slice(decls, func(i, j int) bool {
+			return decls[i].SeqNo < decls[j].SeqNo
+		})
+	}
+	return packages
+}
+
+func sortedOutputs(packages map[string][]*SMDecl) []string {
+	outputs := make([]string, 0, len(packages))
+	for k := range packages {
+		outputs = append(outputs, k)
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// writeSyncReports writes a report of sync objects used by SMs, one per package
+func (p *FileSet) writeSyncReports(singleFile string) error {
+	packages := p.syncReportGroups()
+	for _, pkgOutput := range sortedOutputs(packages) {
+		output := singleFile
+		if output == "" {
+			output = pkgOutput + p.umlExtension
+		}
+		decls := packages[pkgOutput]
+		if err := p.writeFile(output, func(w *umlWriter) {
+			w.writeSyncReport(decls)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *FileSet) writePagedUML(singleFile string, decls []*SMDecl) error {
+	if singleFile != "" {
+		return p.writeUML(singleFile, decls)
+	}
+
+	lastPos := 0
+	lastOut := decls[0].Output
+	for i := 1; i < len(decls); i++ {
+		if decls[i].Output == lastOut {
+			continue
+		}
+		if err := p.writeUML(lastOut+p.umlExtension, decls[lastPos:i]); err != nil {
+			return err
+		}
+		lastOut = decls[i].Output
+		lastPos = i
+	}
+	return p.writeUML(lastOut+p.umlExtension, decls[lastPos:])
+}
+
+func (p *FileSet) writeUML(output string, decls []*SMDecl) error {
+	return p.writeFile(output, func(w *umlWriter) {
+		w.writeUML(decls)
+	})
+}
+
+func (p *FileSet) writeFile(output string, writeFn func(*umlWriter)) (err error) {
+	var file *os.File
+	if output == "-" {
+		file = os.Stdout
+	} else {
+		file, err = os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", output, err)
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to write to file %s: %w", output, closeErr)
+			}
+		}()
+	}
+
+	if err := p.writeTo(file, output, writeFn); err != nil {
+		return fmt.Errorf("failed to write to file %s: %w", output, err)
+	}
+	return nil
+}
+
+func (p *FileSet) writeTo(file io.Writer, output string, writeFn func(*umlWriter)) error {
+	out := bufio.NewWriter(file)
+	w := umlWriter{out: out, output: output, umlExtension: p.umlExtension, usageFilter: p.usageFilter, labels: p.labels}
+	writeFn(&w)
+	if w.err != nil {
+		return w.err
+	}
+	return out.Flush()
+}
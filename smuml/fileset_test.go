@@ -0,0 +1,246 @@
+package smuml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testHeader = `package sm
+
+import "github.com/insolar/assured-ledger/ledger-core/conveyor/smachine"
+
+`
+
+var (
+	testStateDecl = regexp.MustCompile(`^state "([^"]*)" as (\S+)`)
+	testPseudo    = regexp.MustCompile(`^state (\S+) (<<\w+>>)`)
+	testStateID   = regexp.MustCompile(`\bT\d+_[A-Z]+\d*\b`)
+)
+
+func newTestFileSet(t *testing.T, src string, setup ...func(*FileSet)) *FileSet {
+	t.Helper()
+	fs := NewFileSet()
+	for _, fn := range setup {
+		fn(fs)
+	}
+	if err := fs.AddSource("sm.go", []byte(testHeader+src)); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// resolveSM resolves the source and returns the SM of the given type
+func resolveSM(t *testing.T, src, rType string, setup ...func(*FileSet)) *SMDecl {
+	t.Helper()
+	for _, d := range newTestFileSet(t, src, setup...).Resolve() {
+		if d.RType == rType {
+			return d
+		}
+	}
+	t.Fatalf("SM %s is not found", rType)
+	return nil
+}
+
+func renderSource(t *testing.T, src string, setup ...func(*FileSet)) string {
+	t.Helper()
+	b := bytes.Buffer{}
+	if err := newTestFileSet(t, src, setup...).Render(&b, FormatPlantUML); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// renderEdges renders the source and returns its edges and notes with ids of states replaced by their names
+func renderEdges(t *testing.T, src string, setup ...func(*FileSet)) []string {
+	t.Helper()
+	out := renderSource(t, src, setup...)
+
+	names := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if m := testStateDecl.FindStringSubmatch(line); m != nil {
+			names[m[2]] = m[1]
+		} else if m := testPseudo.FindStringSubmatch(line); m != nil {
+			names[m[1]] = m[2]
+		}
+	}
+	var edges []string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" || strings.HasPrefix(line, "@") || strings.HasPrefix(line, "state ") {
+			continue
+		}
+		edges = append(edges, testStateID.ReplaceAllStringFunc(line, func(id string) string {
+			if name, ok := names[id]; ok {
+				return name
+			}
+			return id
+		}))
+	}
+	return edges
+}
+
+func hasEdge(edges []string, edge string) bool {
+	for _, e := range edges {
+		if e == edge {
+			return true
+		}
+	}
+	return false
+}
+
+func requireEdges(t *testing.T, edges []string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !hasEdge(edges, w) {
+			t.Errorf("missing %q in:\n%s", w, strings.Join(edges, "\n"))
+		}
+	}
+}
+
+func rejectEdges(t *testing.T, edges []string, unwanted ...string) {
+	t.Helper()
+	for _, w := range unwanted {
+		if hasEdge(edges, w) {
+			t.Errorf("unexpected %q in:\n%s", w, strings.Join(edges, "\n"))
+		}
+	}
+}
+
+const testSyncSM = `
+type %[1]s struct {
+	smachine.StateMachineDeclTemplate
+	%[2]s smachine.SyncLink
+}
+
+func (s *%[1]s) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.Acquire(s.%[2]s)
+	return ctx.Stop()
+}
+`
+
+func TestRenderSortsSyncReports(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		fs := NewFileSet()
+		for _, src := range []struct{ filename, pkg, sm, link string }{
+			{"zeta/sm.go", "zeta", "SMZeta", "zetaLink"},
+			{"alpha/sm.go", "alpha", "SMAlpha", "alphaLink"},
+		} {
+			text := strings.Replace(testHeader, "package sm", "package "+src.pkg, 1) + fmt.Sprintf(testSyncSM, src.sm, src.link)
+			if err := fs.AddSource(src.filename, []byte(text)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b := bytes.Buffer{}
+		if err := fs.Render(&b, FormatPlantUML); err != nil {
+			t.Fatal(err)
+		}
+		out := b.String()
+		alpha, zeta := strings.Index(out, `node "s.alphaLink"`), strings.Index(out, `node "s.zetaLink"`)
+		if alpha < 0 || zeta < 0 || alpha > zeta {
+			t.Fatalf("sync reports are not sorted by package:\n%s", out)
+		}
+	}
+}
+
+func TestWriteUMLsReturnsError(t *testing.T) {
+	fs := NewFileSet()
+	filename := filepath.Join(t.TempDir(), "missing", "sm.go")
+	if err := fs.AddSource(filename, []byte(testHeader+fmt.Sprintf(testSyncSM, "SMFoo", "link"))); err != nil {
+		t.Fatal(err)
+	}
+	err := fs.WriteUMLs(false)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	const src = `
+type SMOld struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMOld) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if ctx.Acquire(nil).IsNotPassed() {
+		return ctx.Sleep().ThenRepeatOrElse()
+	}
+	return ctx.Sleep().ThenRepeatOrElse()
+}
+`
+	for _, tc := range []struct {
+		api      string
+		warnings int
+	}{
+		{LatestAPI, 1},
+		{"legacy", 0},
+	} {
+		t.Run(tc.api, func(t *testing.T) {
+			fs := NewFileSet()
+			if err := fs.SetAPIVersion(tc.api); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.AddSource("sm.go", []byte(testHeader+src)); err != nil {
+				t.Fatal(err)
+			}
+			warnings := fs.Warnings()
+			if len(warnings) != tc.warnings {
+				t.Fatalf("expected %d warnings, got %q", tc.warnings, warnings)
+			}
+			for _, w := range warnings {
+				if !strings.Contains(w, "SMOld.Init uses ThenRepeatOrElse, deprecated in "+tc.api+" API") {
+					t.Errorf("unexpected warning: %s", w)
+				}
+			}
+		})
+	}
+}
+
+func TestAddReader(t *testing.T) {
+	fs := NewFileSet()
+	src := testHeader + fmt.Sprintf(testSyncSM, "SMFoo", "link")
+	if err := fs.AddReader("stdin.go", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	decls := fs.Resolve()
+	if len(decls) != 1 || decls[0].RType != "SMFoo" || decls[0].Output != "stdin" {
+		t.Fatalf("unexpected SMs: %+v", decls)
+	}
+}
+
+func TestAddSourceAfterResolve(t *testing.T) {
+	fs := newTestFileSet(t, fmt.Sprintf(testSyncSM, "SMFoo", "link"))
+	fs.Resolve()
+	if err := fs.AddSource("bar.go", []byte(testHeader+fmt.Sprintf(testSyncSM, "SMBar", "link"))); err == nil {
+		t.Fatal("a source is added after Resolve")
+	}
+}
+
+func TestStepsUseCatalogueOfTheirAPI(t *testing.T) {
+	const src = `
+type SMOld struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMOld) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.Log()
+	return ctx.Stop()
+}
+`
+	for _, tc := range []struct {
+		api  string
+		role OpRole
+	}{
+		{"legacy", OpJump},
+		{LatestAPI, OpUnsupported},
+	} {
+		sm := resolveSM(t, src, "SMOld", func(fs *FileSet) {
+			if err := fs.SetAPIVersion(tc.api); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if got := sm.Steps["Init"].ops.Lookup("ThenRepeatOrElse").Role; got != tc.role {
+			t.Errorf("%s: got %s, want %s", tc.api, got, tc.role)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package smuml
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxCondLen = 30
+const maxArgLen = 10
+const maxKeyLen = 40
+
+// LabelMode tells how conditions are shown on transitions
+type LabelMode uint8
+
+const (
+	LabelAbbreviated LabelMode = iota // long terms of conditions are shortened by MaxCondLen
+	LabelFull                         // conditions are shown as they are
+	LabelHidden                       // conditions are not shown
+)
+
+// ParseLabelMode returns a label mode by its name: abbreviated, full or hidden
+func ParseLabelMode(s string) (LabelMode, error) {
+	switch s {
+	case "", "abbrev", "abbreviated":
+		return LabelAbbreviated, nil
+	case "full":
+		return LabelFull, nil
+	case "hidden", "none":
+		return LabelHidden, nil
+	}
+	return 0, fmt.Errorf("unknown label mode: %s", s)
+}
+
+// LabelPolicy defines how conditions and arguments are presented on transitions
+type LabelPolicy struct {
+	Mode       LabelMode
+	MaxCondLen int  // max length of a condition term in LabelAbbreviated mode
+	MaxArgLen  int  // max length of an argument of an operation, e.g. Repeat(...)
+	MaxKeyLen  int  // max length of a key of shared data or of a sync object
+	WrapLen    int  // labels are wrapped by words when a line is longer, 0 = no wrap
+	CondTable  bool // conditions are numbered and listed in a side table with full source text
+}
+
+// DefaultLabelPolicy returns default label limits
+func DefaultLabelPolicy() LabelPolicy {
+	return LabelPolicy{MaxCondLen: maxCondLen, MaxArgLen: maxArgLen, MaxKeyLen: maxKeyLen, WrapLen: 40}
+}
+
+// wrap breaks a label into lines of WrapLen by words using PlantUML `\n`
+func (p LabelPolicy) wrap(s string) string {
+	if p.WrapLen <= 0 || len(s) <= p.WrapLen {
+		return s
+	}
+
+	b := strings.Builder{}
+	for i, line := range strings.Split(s, `\n`) {
+		if i > 0 {
+			b.WriteString(`\n`)
+		}
+		lineLen := 0
+		for j, word := range strings.Split(line, " ") {
+			switch {
+			case j == 0:
+			case lineLen+1+len(word) > p.WrapLen:
+				b.WriteString(`\n`)
+				lineLen = 0
+			default:
+				b.WriteByte(' ')
+				lineLen++
+			}
+			b.WriteString(word)
+			lineLen += len(word)
+		}
+	}
+	return b.String()
+}
+
+// condLabel returns a condition label according to the policy, and registers it in the condition table.
+// A source comment, when present, is used as a label instead of the condition.
+func (p *umlWriter) condLabel(cond, fullCond, comment string) string {
+	if comment != "" {
+		if cond != "" && p.labels.CondTable {
+			p.condTable = append(p.condTable, fullCond)
+			comment = fmt.Sprintf("C%d: %s", len(p.condTable), comment)
+		}
+		return p.labels.wrap(escapeDesc(comment))
+	}
+	if cond == "" && fullCond == "" {
+		return ""
+	}
+	if fullCond == "" {
+		fullCond = cond
+	}
+
+	ref := ""
+	if p.labels.CondTable {
+		p.condTable = append(p.condTable, fullCond)
+		ref = fmt.Sprintf("C%d", len(p.condTable))
+	}
+
+	switch p.labels.Mode {
+	case LabelHidden:
+		return ref
+	case LabelFull:
+		cond = fullCond
+	}
+
+	if ref != "" {
+		cond = ref + `: ` + cond
+	}
+	return p.labels.wrap(cond)
+}
+
+// writeCondTable writes a legend with full source text of numbered conditions
+func (p *umlWriter) writeCondTable() {
+	if len(p.condTable) == 0 {
+		return
+	}
+	p.line(`legend right`)
+	p.line(`|= # |= condition |`)
+	for i, cond := range p.condTable {
+		cond = strings.ReplaceAll(cond, `\n`, ` `)
+		cond = strings.ReplaceAll(cond, `|`, `<U+007C>`)
+		p.line(fmt.Sprintf("| C%d | %s |", i+1, cond))
+	}
+	p.line(`endlegend`)
+	p.condTable = nil
+}
@@ -0,0 +1,92 @@
+package smuml
+
+import "testing"
+
+func TestWrap(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		wrapLen int
+		label   string
+		want    string
+	}{
+		{"disabled", 0, "aaa bbb ccc", "aaa bbb ccc"},
+		{"short", 20, "aaa bbb ccc", "aaa bbb ccc"},
+		{"by words", 7, "aaa bbb ccc", `aaa bbb\nccc`},
+		{"long word", 3, "aaaaa bb", `aaaaa\nbb`},
+		{"existing breaks", 7, `aaa bbb ccc\nddd`, `aaa bbb\nccc\nddd`},
+	} {
+		if got := (LabelPolicy{WrapLen: tc.wrapLen}).wrap(tc.label); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+const testLabelsSM = `
+type SMLabels struct {
+	smachine.StateMachineDeclTemplate
+	value int
+}
+
+func (s *SMLabels) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if s.value > 100 {
+		return ctx.Jump(s.Init)
+	}
+	return ctx.Stop()
+}
+`
+
+func renderWithLabels(t *testing.T, src string, setup func(*LabelPolicy)) []string {
+	t.Helper()
+	labels := DefaultLabelPolicy()
+	setup(&labels)
+	return renderEdges(t, src, func(fs *FileSet) { fs.SetLabelPolicy(labels) })
+}
+
+func TestLabelAbbreviated(t *testing.T) {
+	edges := renderWithLabels(t, testLabelsSM, func(p *LabelPolicy) { p.MaxCondLen = 5 })
+	requireEdges(t, edges,
+		"Init --> Init : [(...).value>100]",
+		"Init --> [*] : [...<=100]",
+	)
+}
+
+func TestLabelHidden(t *testing.T) {
+	edges := renderWithLabels(t, testLabelsSM, func(p *LabelPolicy) { p.Mode = LabelHidden })
+	requireEdges(t, edges, "Init --> Init", "Init --> [*]")
+}
+
+func TestLabelCondTable(t *testing.T) {
+	edges := renderWithLabels(t, testLabelsSM, func(p *LabelPolicy) { p.CondTable = true })
+	requireEdges(t, edges,
+		"Init --> Init : C1: [s.value>100]",
+		"Init --> [*] : C2: [s.value<=100]",
+		"| C1 | [s.value > 100] |",
+		"| C2 | [s.value <= 100] |",
+	)
+}
+
+func TestMaxKeyLen(t *testing.T) {
+	const src = `
+type SMKeys struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMKeys) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.Publish("a rather long key", nil)
+	return ctx.Stop()
+}
+`
+	for _, tc := range []struct {
+		maxKeyLen int
+		key       string
+	}{
+		{maxKeyLen, `"a rather long key"`},
+		{6, `"a rat...`},
+		{-1, `...`},
+	} {
+		labels := DefaultLabelPolicy()
+		labels.MaxKeyLen = tc.maxKeyLen
+		sm := resolveSM(t, src, "SMKeys", func(fs *FileSet) { fs.SetLabelPolicy(labels) })
+		if got := sm.Steps["Init"].Exchanges[0].Key; got != tc.key {
+			t.Errorf("MaxKeyLen %d: got %s, want %s", tc.maxKeyLen, got, tc.key)
+		}
+	}
+}
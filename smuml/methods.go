@@ -0,0 +1,503 @@
+package smuml
+
+import (
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+// MethodType is a kind of SM method by its signature
+type MethodType uint8
+
+// HasStateUpdate returns true for a method that returns StateUpdate
+func (t MethodType) HasStateUpdate() bool {
+	return t >= Initialization
+}
+
+// HasContextArg returns true for a method that gets a context of smachine
+func (t MethodType) HasContextArg() bool {
+	return t >= Construction
+}
+
+// Types of SM methods, the order follows HasContextArg and HasStateUpdate
+const (
+	_ MethodType = iota
+	DeclarationInit
+	Construction
+	Failure
+	Initialization
+	Execution
+	Migration
+	BargeIn
+)
+
+// MethodDecl is a step of SM, or another method of SM that is traced, e.g. GetInitStateFor
+type MethodDecl struct {
+	SM     *SMDecl
+	RType  string
+	RName  string
+	Name   string
+	Label  string
+	Group  string
+	Doc    string
+	CtxArg string
+	MType  MethodType
+
+	UpdateArg string
+	UpdateIdx int
+
+	TypeParams []string // names of type params of a generic receiver
+
+	Transitions []MethodTransition
+	Children    []MethodChild
+	Exchanges   []MethodExchange
+	SyncOps     []MethodSyncOp
+	BargeIns    []MethodBargeIn
+	SubSteps    []*MethodDecl
+	RepeatTrIdx int
+
+	Usages        map[string]int
+	Migrations    map[string]struct{}
+	ErrorHandlers map[string]struct{}
+	StepFlags     map[string]struct{}
+	StepNo        int
+	InheritedFrom string // a type of embedded SM the step is promoted from
+	Overrides     string // a type of embedded SM which step is overridden by this one
+	Shadowed      bool   // an embedded step overridden by the embedding SM
+	Duplicate     bool
+	IsSubroutine  bool
+	CanPropagate  bool
+
+	DeclName string // a name given by StepDeclaration
+
+	inlined      map[*ast.FuncLit]string
+	inlinedNames map[*ast.FuncLit]string
+	ops          OpCatalogue // operations of the API used by the source of the step, for usage categories
+}
+
+// Pseudo transitions that terminate the SM, they are rendered as distinct terminal states
+const (
+	TerminalStop    = "<stop>"
+	TerminalError   = "<error>"
+	TerminalPanic   = "<panic>"
+	TerminalReplace = "<replace>"
+)
+
+// IsTerminal returns true for a pseudo transition that terminates the SM
+func IsTerminal(transition string) bool {
+	switch transition {
+	case TerminalStop, TerminalError, TerminalPanic, TerminalReplace:
+		return true
+	}
+	return false
+}
+
+// MethodTransition is a transition made by a step under a condition
+type MethodTransition struct {
+	Condition     string
+	FullCondition string
+	Comment       string
+	Operation     string
+	Transition    string
+	Migration     string
+	ErrorHandler  string
+	StepFlags     string
+	SlotHandler   string // an error handler of the target step set by SlotStep
+	SlotFlags     string // flags of the target step set by SlotStep
+
+	DelayedStart string
+
+	HiddenPropagate string
+
+	SyncLink    string
+	IndirectVia string // a field the transition goes through
+	Result      string // a termination result set by SetTerminationResult before Stop
+	Repeatable  bool   // the transition is made inside of a loop
+
+	InheritMigration    bool
+	InheritErrorHandler bool
+	InheritStepFlags    bool
+	WaitTransition      bool
+
+	TransitionTo *MethodDecl
+	HiddenPropTo *MethodDecl
+	MigrationTo  *MethodDecl
+}
+
+// MethodChild is a child SM created by a step with NewChild, NewChildExt or InitChild.
+// The step doesn't leave on this, so it is rendered as a fork rather than as a transition.
+type MethodChild struct {
+	Condition     string
+	FullCondition string
+	Operation     string
+	Child         string // type of the child SM, when resolved from the constructor closure
+	Constructor   string // name of a func that returns the child SM, when Child is unknown
+
+	ChildTo *SMDecl
+}
+
+// ExchangeKind is a kind of access to data of other SMs
+type ExchangeKind uint8
+
+// Kinds of exchanges by operations of the context: Share, Publish, GetPublished, UseShared and Unpublish
+const (
+	_ ExchangeKind = iota
+	ExchangeShare
+	ExchangePublish
+	ExchangeSubscribe
+	ExchangeUse
+	ExchangeUnpublish
+)
+
+// IsProvider returns true when data is made available to other SMs by this kind of exchange
+func (k ExchangeKind) IsProvider() bool {
+	return k == ExchangeShare || k == ExchangePublish
+}
+
+// MethodExchange is an access to shared or published data made by a step.
+// Key is a source text of the published key, or of the shared data / link when there is no key.
+type MethodExchange struct {
+	Kind      ExchangeKind
+	Operation string
+	Key       string
+}
+
+// MethodSyncOp is an operation of a step on a sync object (semaphore, mutex, barrier etc)
+type MethodSyncOp struct {
+	Operation string
+	Link      string
+}
+
+// MethodBargeIn is a barge-in declared by a step. It is an external event that can interrupt
+// the SM in any state, so it is rendered from a pseudo state rather than from the declaring step.
+type MethodBargeIn struct {
+	Name       string // a variable or a field the barge-in is assigned to
+	Operation  string
+	Transition string // a step to jump to or a callback traced as a sub-step
+}
+
+// Categories of context calls shown on steps, see OpCatalogue.UsageCategory
+const (
+	UsageLog      = "log"
+	UsageDefaults = "defaults"
+	UsageShared   = "shared"
+	UsageSync     = "sync"
+	UsageChild    = "child"
+	UsageBargeIn  = "bargein"
+	UsageOther    = "other"
+)
+
+func (p *MethodDecl) parseFuncBody(bodyAst *ast.BlockStmt, fs *sourceFile) {
+	if bodyAst == nil {
+		return
+	}
+
+	et := execTrace{md: p, fs: fs, gotoTargets: collectLabels(bodyAst)}
+	et.parseStatements(bodyAst.List)
+	et.traceGotos()
+
+	p.Usages = et.usages
+	p.ops = fs.ops
+}
+
+func (p *MethodDecl) nameInlineFunc(lit *ast.FuncLit, name string) {
+	if p.inlinedNames == nil {
+		p.inlinedNames = map[*ast.FuncLit]string{}
+	}
+	if _, ok := p.inlinedNames[lit]; !ok {
+		p.inlinedNames[lit] = name
+	}
+}
+
+// inlineFuncName returns a name for a sub-step of an inline func, numbered when it wasn't assigned to a variable
+func (p *MethodDecl) inlineFuncName(lit *ast.FuncLit) string {
+	if name := p.inlinedNames[lit]; name != "" {
+		funcName := p.Name + `.` + name
+		unique := true
+		for _, sub := range p.SubSteps {
+			if sub.Name == funcName {
+				unique = false
+				break
+			}
+		}
+		if unique {
+			return funcName
+		}
+	}
+	return p.Name + `.` + strconv.Itoa(len(p.SubSteps)+1)
+}
+
+// DisplayName returns a name of the step given by a directive, by StepDeclaration or by the doc comment
+func (p *MethodDecl) DisplayName() string {
+	switch {
+	case p.Label != "":
+		return p.Label
+	case p.DeclName != "":
+		return p.DeclName
+	}
+	if name := p.DocName(); name != "" {
+		return name
+	}
+	return p.Name
+}
+
+// DocName returns a name of the step from the first sentence of its doc comment without a leading method name,
+// e.g. "stepWaitForData waits for data." gives "waits for data"
+func (p *MethodDecl) DocName() string {
+	if p.Doc == "" {
+		return ""
+	}
+	s := firstLine(p.Doc)
+	if n := strings.Index(s, ". "); n >= 0 {
+		s = s[:n]
+	}
+	s = strings.TrimSuffix(s, ".")
+	if name := p.Name; name != "" && strings.HasPrefix(s, name+" ") {
+		s = s[len(name)+1:]
+	}
+	return strings.TrimSpace(s)
+}
+
+func (p *MethodDecl) addMigration(migration string) bool {
+	return addToSet(&p.Migrations, migration)
+}
+
+func (p *MethodDecl) addMigrations(step *MethodDecl) bool {
+	return addAllToSet(&p.Migrations, step.Migrations)
+}
+
+func (p *MethodDecl) addErrorHandler(handler string) bool {
+	return addToSet(&p.ErrorHandlers, handler)
+}
+
+func (p *MethodDecl) addStepFlags(flags string) bool {
+	return addToSet(&p.StepFlags, flags)
+}
+
+// addDefaults adds settings of the step that are inherited by the given transition, nil transition inherits all
+func (p *MethodDecl) addDefaults(step *MethodDecl, tr *MethodTransition) bool {
+	result := false
+	if (tr == nil || tr.InheritMigration) && p.addMigrations(step) {
+		result = true
+	}
+	if (tr == nil || tr.InheritErrorHandler) && addAllToSet(&p.ErrorHandlers, step.ErrorHandlers) {
+		result = true
+	}
+	if (tr == nil || tr.InheritStepFlags) && addAllToSet(&p.StepFlags, step.StepFlags) {
+		result = true
+	}
+	return result
+}
+
+func addToSet(set *map[string]struct{}, k string) bool {
+	if *set == nil {
+		*set = map[string]struct{}{k: {}}
+		return true
+	}
+	if _, ok := (*set)[k]; !ok {
+		(*set)[k] = struct{}{}
+		return true
+	}
+	return false
+}
+
+func addAllToSet(set *map[string]struct{}, from map[string]struct{}) bool {
+	result := false
+	for k := range from {
+		if addToSet(set, k) {
+			result = true
+		}
+	}
+	return result
+}
+
+func (p *MethodDecl) findSubStep(name string) *MethodDecl {
+	for _, sub := range p.SubSteps {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (p *MethodDecl) removeSubStep(name string) {
+	for i, sub := range p.SubSteps {
+		if sub.Name == name {
+			p.SubSteps = append(p.SubSteps[:i:i], p.SubSteps[i+1:]...)
+			return
+		}
+	}
+}
+
+// setTransitions replaces transitions of the step and finds the repeat transition among them
+func (p *MethodDecl) setTransitions(transitions []MethodTransition) {
+	p.Transitions = transitions
+	p.RepeatTrIdx = 0
+	for i, tr := range transitions {
+		if tr.Transition == "" && tr.DelayedStart == "" {
+			p.RepeatTrIdx = i
+			break
+		}
+	}
+}
+
+func (p *MethodDecl) getRepeatTransitionIdx() int {
+	switch {
+	case p.RepeatTrIdx > 0:
+		return p.RepeatTrIdx
+	case len(p.Transitions) == 0:
+		return -1
+	case p.Transitions[0].Transition == "":
+		return 0
+	default:
+		return -1
+	}
+}
+
+func (p *MethodDecl) addTransition(tr MethodTransition) {
+	if tr.Transition != "" || tr.DelayedStart != "" {
+		p.Transitions = append(p.Transitions, tr)
+		return
+	}
+
+	if tr.Migration != "" {
+		p.addMigration(tr.Migration)
+	}
+	if tr.ErrorHandler != "" {
+		p.addErrorHandler(tr.ErrorHandler)
+	}
+	if tr.StepFlags != "" {
+		p.addStepFlags(tr.StepFlags)
+	}
+
+	repeatIdx := p.getRepeatTransitionIdx()
+	if repeatIdx < 0 {
+		p.RepeatTrIdx = len(p.Transitions)
+		p.Transitions = append(p.Transitions, tr)
+		return
+	}
+
+	repTr := &p.Transitions[repeatIdx]
+
+	switch {
+	case tr.Condition == "":
+	case repTr.Condition == "":
+		repTr.Condition, repTr.FullCondition = tr.Condition, tr.FullCondition
+	default:
+		repTr.Condition += `\n` + tr.Condition
+		repTr.FullCondition += `\n` + tr.FullCondition
+	}
+
+	switch {
+	case tr.Comment == "":
+	case repTr.Comment == "":
+		repTr.Comment = tr.Comment
+	case repTr.Comment != tr.Comment:
+		repTr.Comment += `; ` + tr.Comment
+	}
+
+	switch {
+	case tr.Operation == "":
+	case repTr.Operation == "":
+		repTr.Operation = tr.Operation
+	case strings.Contains(repTr.Operation, tr.Operation):
+	default:
+		repTr.Operation += `, ` + tr.Operation
+	}
+}
+
+func (p *MethodDecl) addExchange(ex MethodExchange) {
+	for _, e := range p.Exchanges {
+		if e == ex {
+			return
+		}
+	}
+	p.Exchanges = append(p.Exchanges, ex)
+}
+
+func (p *MethodDecl) addSyncOp(op MethodSyncOp) {
+	for _, o := range p.SyncOps {
+		if o == op {
+			return
+		}
+	}
+	p.SyncOps = append(p.SyncOps, op)
+}
+
+// inheritedCopy makes a copy of a step promoted from the embedded SM, transitions to overridden steps are
+// redirected to the qualified names of base steps
+func (p *MethodDecl) inheritedCopy(baseType string, overridden map[string]string) *MethodDecl {
+	cp := *p
+	if cp.InheritedFrom == "" {
+		cp.InheritedFrom = baseType
+	}
+	cp.Overrides = ""
+	cp.Migrations = cloneSet(p.Migrations)
+	cp.ErrorHandlers = cloneSet(p.ErrorHandlers)
+	cp.StepFlags = cloneSet(p.StepFlags)
+
+	cp.Transitions = make([]MethodTransition, len(p.Transitions))
+	for i, tr := range p.Transitions {
+		tr.TransitionTo, tr.HiddenPropTo, tr.MigrationTo = nil, nil, nil
+		tr.Transition = redirectName(tr.Transition, overridden)
+		tr.HiddenPropagate = redirectName(tr.HiddenPropagate, overridden)
+		cp.Transitions[i] = tr
+	}
+	return &cp
+}
+
+// redirectName matches a transition target the same way as SMDecl.findStep does, e.g. s.stepX matches stepX
+func redirectName(name string, overridden map[string]string) string {
+	for short := name; short != ""; {
+		if qualified, ok := overridden[short]; ok {
+			return qualified
+		}
+		n := strings.IndexByte(short, '.')
+		if n < 0 {
+			break
+		}
+		short = short[n+1:]
+	}
+	return name
+}
+
+func cloneSet(set map[string]struct{}) map[string]struct{} {
+	if set == nil {
+		return nil
+	}
+	cp := make(map[string]struct{}, len(set))
+	for k := range set {
+		cp[k] = struct{}{}
+	}
+	return cp
+}
+
+func (p *MethodDecl) addAdapterCall(name, prepType, adapter string) {
+	if name == "" {
+		return
+	}
+
+	p.Transitions = append(p.Transitions, MethodTransition{
+		Operation:  prepType, // + `.` + name,
+		Transition: adapter,
+	})
+
+	p.addAdapter(adapter)
+}
+
+func (p *MethodDecl) addAdapter(adapter string) {
+	if adapter == "" {
+		return
+	}
+
+	for _, sub := range p.SubSteps {
+		if sub.Name == adapter {
+			return
+		}
+	}
+
+	p.SubSteps = append(p.SubSteps, &MethodDecl{
+		Name:         adapter,
+		IsSubroutine: true,
+	})
+}
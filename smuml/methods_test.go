@@ -0,0 +1,47 @@
+package smuml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultErrorHandler(t *testing.T) {
+	const src = `
+type SMHandler struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMHandler) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.SetDefaultErrorHandler(s.onError)
+	ctx.SetDefaultFlags(smachine.StepWeak)
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMHandler) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+
+func (s *SMHandler) onError(ctx smachine.FailureContext) {
+}
+`
+	sm := resolveSM(t, src, "SMHandler")
+	if handler := sm.Steps["onError"]; handler == nil || handler.MType != Failure {
+		t.Fatalf("onError is not an error handler: %+v", handler)
+	}
+	step := sm.Steps["stepWork"]
+	if _, ok := step.ErrorHandlers["s.onError"]; !ok {
+		t.Errorf("the default handler is not propagated: %v", step.ErrorHandlers)
+	}
+	if _, ok := step.StepFlags["weak"]; !ok {
+		t.Errorf("the default flags are not propagated: %v", step.StepFlags)
+	}
+
+	out := renderSource(t, src)
+	for _, line := range []string{
+		`state "onError" as T00_S003 <<error handler>>`,
+		`state "stepWork" as T00_S002 <<weak>>`,
+		"T00_S002 --[#red,dotted]> T00_S003",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package smuml
+
+import (
+	"encoding/json"
+	"go/ast"
+	"os"
+	"strings"
+)
+
+// OpRole is a role of a context method of smachine for the tracer
+type OpRole string
+
+const (
+	OpJump        OpRole = "jump"            // a transition to a step given by Arg, without args it repeats the step
+	OpRepeatJump  OpRole = "repeat-jump"     // repeats the step, then makes a transition to Arg, e.g. ThenRepeatOrJump
+	OpRepeat      OpRole = "repeat"          // repeats the step a given number of times
+	OpStay        OpRole = "stay"            // doesn't change the step
+	OpRestore     OpRole = "restore"         // waits and returns to a previous step
+	OpWait        OpRole = "wait"            // a condition for a following Then-operation, e.g. Sleep or WaitAny
+	OpTerminal    OpRole = "terminal"        // terminates the SM, see OpSpec.Terminal
+	OpSubroutine  OpRole = "subroutine"      // CallSubroutine(SM, MigrateFunc, ExitFunc)
+	OpUnsupported OpRole = "unsupported"     // is recognized, but a transition can't be traced
+	OpSetter      OpRole = "setter"          // sets a default of the step, see OpSpec.Setting
+	OpAdapter     OpRole = "adapter"         // starts an adapter call prepared by OpAdapterPrepare, e.g. Start or Send
+	OpAdapterPrep OpRole = "adapter-prepare" // prepares an adapter call, e.g. PrepareAsync
+	OpChild       OpRole = "child"           // creates a child SM by CreateFunc at Arg
+	OpExchange    OpRole = "exchange"        // shares or publishes data, see OpSpec.Exchange
+	OpBargeIn     OpRole = "bargein"         // declares a barge-in, with Then it is a method of the barge-in builder
+	OpSyncAcquire OpRole = "sync-acquire"    // acquires a sync object at Arg, a following wait is for this object
+	OpSyncRelease OpRole = "sync-release"    // releases or adjusts a sync object at Arg
+	OpLog         OpRole = "log"             // writes to the step log
+)
+
+// buildsUpdate returns true for a role of a method that makes a StateUpdate or its builder
+func (r OpRole) buildsUpdate() bool {
+	switch r {
+	case OpJump, OpRepeatJump, OpRepeat, OpStay, OpRestore, OpWait, OpTerminal, OpSubroutine:
+		return true
+	}
+	return false
+}
+
+// OpSpec describes a context method of smachine
+type OpSpec struct {
+	Role       OpRole `json:"role"`
+	Arg        int    `json:"arg,omitempty"`        // a position of the main argument, -1 is the last one
+	SlotStep   bool   `json:"slotStep,omitempty"`   // the main argument is SlotStep rather than StateFunc
+	Machine    bool   `json:"machine,omitempty"`    // the main argument is StateMachine rather than CreateFunc
+	Then       bool   `json:"then,omitempty"`       // a continuation of OpWait or OpBargeIn, e.g. ThenJump or WithJump
+	Terminal   string `json:"terminal,omitempty"`   // stop, error or replace
+	Setting    string `json:"setting,omitempty"`    // migration, flags, handler or result
+	Exchange   string `json:"exchange,omitempty"`   // share, publish, subscribe, use, access or unpublish
+	Usage      string `json:"usage,omitempty"`      // a usage category, when it doesn't follow the role
+	Deprecated string `json:"deprecated,omitempty"` // a reason or a replacement, when the method is deprecated
+}
+
+// OpCatalogue maps names of context methods to their specs. A name ending with * matches by prefix.
+type OpCatalogue map[string]OpSpec
+
+// DefaultOpCatalogue returns operations of the current smachine API
+func DefaultOpCatalogue() OpCatalogue {
+	return OpCatalogue{
+		"Jump":                {Role: OpJump},
+		"JumpExt":             {Role: OpJump, SlotStep: true},
+		"Then*":               {Role: OpJump, Then: true},
+		"ThenJumpExt":         {Role: OpJump, Then: true, SlotStep: true},
+		"ThenRepeatOrJump":    {Role: OpRepeatJump},
+		"ThenRepeatOrJumpExt": {Role: OpRepeatJump, SlotStep: true},
+		"ThenRepeatOrElse":    {Role: OpUnsupported, Deprecated: "to be removed, use ThenRepeatOrJump"},
+		"Repeat":              {Role: OpRepeat},
+		"Stay":                {Role: OpStay},
+		"RestoreStep":         {Role: OpRestore, SlotStep: true},
+		"Sleep":               {Role: OpWait},
+		"Wait*":               {Role: OpWait},
+
+		"Stop":           {Role: OpTerminal, Terminal: "stop"},
+		"Error":          {Role: OpTerminal, Terminal: "error"},
+		"Errorf":         {Role: OpTerminal, Terminal: "error"},
+		"Replace":        {Role: OpTerminal, Terminal: "replace"},
+		"ReplaceWith":    {Role: OpTerminal, Terminal: "replace", Machine: true},
+		"CallSubroutine": {Role: OpSubroutine},
+
+		"SetDefaultMigration":    {Role: OpSetter, Setting: "migration"},
+		"SetDefaultFlags":        {Role: OpSetter, Setting: "flags"},
+		"SetDefaultErrorHandler": {Role: OpSetter, Setting: "handler"},
+		"SetDefault*":            {Role: OpSetter},
+		"SetTerminationResult":   {Role: OpSetter, Setting: "result", Usage: UsageOther},
+
+		"Start":    {Role: OpAdapter},
+		"Send":     {Role: OpAdapter},
+		"Prepare*": {Role: OpAdapterPrep},
+
+		"NewChild*":   {Role: OpChild},
+		"NewChildExt": {Role: OpChild, Arg: -1},
+		"InitChild*":  {Role: OpChild},
+
+		"Share":          {Role: OpExchange, Exchange: "share"},
+		"Publish*":       {Role: OpExchange, Exchange: "publish"},
+		"GetPublished*":  {Role: OpExchange, Exchange: "subscribe"},
+		"UseShared":      {Role: OpExchange, Exchange: "use"},
+		"TryUse":         {Role: OpExchange, Exchange: "use"},
+		"PrepareAccess*": {Role: OpExchange, Exchange: "access"},
+		"Unpublish*":     {Role: OpExchange, Exchange: "unpublish"},
+
+		"NewBargeIn*":         {Role: OpBargeIn},
+		"NewBargeInWithParam": {Role: OpBargeIn},
+		"WithJump":            {Role: OpBargeIn, Then: true},
+		"WithStop":            {Role: OpBargeIn, Then: true, Terminal: "stop"},
+		"WithError":           {Role: OpBargeIn, Then: true, Terminal: "error"},
+		"WithWakeUp":          {Role: OpBargeIn, Then: true},
+
+		"Acquire*":        {Role: OpSyncAcquire},
+		"Release*":        {Role: OpSyncRelease},
+		"ApplyAdjustment": {Role: OpSyncRelease},
+
+		"Log*": {Role: OpLog},
+	}
+}
+
+// LoadFile overrides operations by a JSON file of {"Name": {"role": "jump", ...}}, entries replace built-in ones
+func (c OpCatalogue) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	ops := OpCatalogue{}
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return err
+	}
+	for name, spec := range ops {
+		c[name] = spec
+	}
+	return nil
+}
+
+// Lookup returns a spec of the operation, an exact name takes precedence over the longest matching prefix
+func (c OpCatalogue) Lookup(name string) OpSpec {
+	if spec, ok := c[name]; ok {
+		return spec
+	}
+	var result OpSpec
+	matched := 0
+	for k, spec := range c {
+		if prefix := strings.TrimSuffix(k, "*"); len(prefix) < len(k) && len(prefix) > matched && strings.HasPrefix(name, prefix) {
+			result, matched = spec, len(prefix)
+		}
+	}
+	return result
+}
+
+// UsageCategory returns a category of a context method for filtering of usages
+func (c OpCatalogue) UsageCategory(name string) string {
+	spec := c.Lookup(name)
+	if spec.Usage != "" {
+		return spec.Usage
+	}
+	switch spec.Role {
+	case OpLog:
+		return UsageLog
+	case OpSetter:
+		return UsageDefaults
+	case OpExchange:
+		return UsageShared
+	case OpSyncAcquire, OpSyncRelease:
+		return UsageSync
+	case OpChild:
+		return UsageChild
+	case OpBargeIn:
+		return UsageBargeIn
+	default:
+		return UsageOther
+	}
+}
+
+// ArgOf returns the main argument of the operation
+func (s OpSpec) ArgOf(args []ast.Expr) ast.Expr {
+	switch {
+	case len(args) == 0:
+		return nil
+	case s.Arg < 0:
+		return args[len(args)-1]
+	case s.Arg < len(args):
+		return args[s.Arg]
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package smuml
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	ops := OpCatalogue{
+		"Then*":      {Role: OpJump, Then: true},
+		"ThenRepeat": {Role: OpRepeat},
+		"ThenRe*":    {Role: OpWait},
+		"Log*":       {Role: OpLog},
+	}
+	for _, tc := range []struct {
+		name string
+		want OpRole
+	}{
+		{"ThenRepeat", OpRepeat},
+		{"ThenRepeatOrJump", OpWait},
+		{"ThenJump", OpJump},
+		{"Then", OpJump},
+		{"LogAsync", OpLog},
+		{"Jump", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ops.Lookup(tc.name).Role; got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUsageCategory(t *testing.T) {
+	ops := DefaultOpCatalogue()
+	for name, want := range map[string]string{
+		"LogAsync":             UsageLog,
+		"SetDefaultFlags":      UsageDefaults,
+		"SetTerminationResult": UsageOther,
+		"Share":                UsageShared,
+		"AcquireForThisStep":   UsageSync,
+		"NewChild":             UsageChild,
+		"NewBargeInWithParam":  UsageBargeIn,
+		"Unknown":              UsageOther,
+	} {
+		if got := ops.UsageCategory(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func loadTestOperations(t *testing.T, ops string) func(*FileSet) {
+	path := filepath.Join(t.TempDir(), "ops.json")
+	if err := os.WriteFile(path, []byte(ops), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return func(fs *FileSet) {
+		if err := fs.LoadOperations(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadOperations(t *testing.T) {
+	const src = `
+type SMOps struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMOps) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.GoTo(s.stepNext)
+}
+
+func (s *SMOps) stepNext(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	edges := renderEdges(t, src, loadTestOperations(t, `{"GoTo": {"role": "jump"}}`))
+	requireEdges(t, edges, "Init --> stepNext")
+}
+
+func TestRemappedOperations(t *testing.T) {
+	const src = `
+type SMOps struct {
+	smachine.StateMachineDeclTemplate
+	link smachine.SharedDataLink
+}
+
+type SMNext struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMOps) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.Interrupt().Abort()
+	ctx.Interrupt().Resume(s.stepNext)
+	return ctx.Jump(s.stepNext)
+}
+
+func (s *SMOps) stepNext(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	s.link.Access(func(interface{}) {}).Borrow(ctx)
+	return ctx.Swap(&SMNext{})
+}
+`
+	const ops = `{
+	"Interrupt": {"role": "bargein"},
+	"Abort":     {"role": "bargein", "then": true, "terminal": "error"},
+	"Resume":    {"role": "bargein", "then": true},
+	"Borrow":    {"role": "exchange", "exchange": "use"},
+	"Access":    {"role": "exchange", "exchange": "access"},
+	"Swap":      {"role": "terminal", "terminal": "replace", "machine": true}
+}`
+	sm := resolveSM(t, src, "SMOps", loadTestOperations(t, ops))
+
+	bargeIns := map[string]string{}
+	for _, bi := range sm.Steps["Init"].BargeIns {
+		bargeIns[bi.Operation] = bi.Transition
+	}
+	if len(bargeIns) != 2 || bargeIns["Abort"] != TerminalError || bargeIns["Resume"] != "s.stepNext" {
+		t.Errorf("unexpected barge-ins: %v", bargeIns)
+	}
+
+	step := sm.Steps["stepNext"]
+	if len(step.Exchanges) != 1 || step.Exchanges[0].Kind != ExchangeUse || step.Exchanges[0].Key != "link" {
+		t.Errorf("unexpected exchanges: %+v", step.Exchanges)
+	}
+	if len(step.Transitions) != 1 || step.Transitions[0].Operation != "Replace: SMNext" {
+		t.Errorf("unexpected transitions: %+v", step.Transitions)
+	}
+}
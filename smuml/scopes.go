@@ -0,0 +1,249 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// branchScope is a statement that can be a target of break or continue: a loop, a switch or a select
+type branchScope struct {
+	label string
+	loop  bool
+	exits []traceSettings // settings at break and continue of a loop
+}
+
+// labelTarget is a position of a labeled statement, where goto continues
+type labelTarget struct {
+	list   []ast.Stmt
+	idx    int
+	traced bool
+	gotos  []gotoSource
+}
+
+// gotoSource is a goto with conditions of its enclosing traces, as they are narrowed by statements after the goto
+type gotoSource struct {
+	et    *execTrace
+	conds map[*execTrace]ast.Expr
+}
+
+// traceSettings are context settings that are carried to a continuation
+type traceSettings struct {
+	migration    ast.Expr
+	errorHandler ast.Expr
+	stepFlags    ast.Expr
+}
+
+func (p *execTrace) settings() traceSettings {
+	return traceSettings{p.migration, p.errorHandler, p.stepFlags}
+}
+
+// sameAs compares settings by source text, as the same setting can be made by different statements
+func (s traceSettings) sameAs(o traceSettings) bool {
+	return exprText(s.migration) == exprText(o.migration) &&
+		exprText(s.errorHandler) == exprText(o.errorHandler) &&
+		exprText(s.stepFlags) == exprText(o.stepFlags)
+}
+
+func exprText(expr ast.Expr) string {
+	if expr == nil {
+		return ""
+	}
+	return types.ExprString(expr)
+}
+
+func (p *execTrace) applySettings(s traceSettings) {
+	p.migration, p.errorHandler, p.stepFlags = s.migration, s.errorHandler, s.stepFlags
+}
+
+// collectLabels finds labeled statements of a func body, labels of inline funcs are not included
+func collectLabels(body *ast.BlockStmt) map[string]*labelTarget {
+	var labels map[string]*labelTarget
+	addList := func(list []ast.Stmt) {
+		for i, stmt := range list {
+			if ls, ok := stmt.(*ast.LabeledStmt); ok && ls.Label != nil {
+				if labels == nil {
+					labels = map[string]*labelTarget{}
+				}
+				labels[ls.Label.Name] = &labelTarget{list: list, idx: i}
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch op := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BlockStmt:
+			addList(op.List)
+		case *ast.CaseClause:
+			addList(op.Body)
+		case *ast.CommClause:
+			addList(op.Body)
+		}
+		return true
+	})
+	return labels
+}
+
+func (p *execTrace) findScope(tok token.Token, label string) *branchScope {
+	for et := p; et != nil; et = et.parent {
+		switch s := et.scope; {
+		case s == nil:
+		case label != "":
+			if s.label == label {
+				return s
+			}
+		case tok == token.CONTINUE && !s.loop:
+		default:
+			return s
+		}
+	}
+	return nil
+}
+
+// inLoop returns true when a transition can be made on any iteration of a loop
+func (p *execTrace) inLoop() bool {
+	for et := p; et != nil; et = et.parent {
+		if et.scope != nil && et.scope.loop {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLoop parses a body of for or range. The body is traced as a branch, and settings reach the statement
+// after the loop only when all exits of the loop (break, continue and the end of the body) agree on them.
+// The next iteration isn't traced with settings made by the previous one.
+func (p *execTrace) parseLoop(loop ast.Stmt, label string, cond ast.Expr, body *ast.BlockStmt) {
+	if body == nil {
+		return
+	}
+
+	et := p.spawnCond(cond, p.fs.commentOf(loop))
+	et.alt = loop
+	et.scope = &branchScope{label: label, loop: true}
+
+	inner := et.spawn()
+	su := inner._parseStatements(body.List)
+	if su != nil {
+		inner.addExit(su)
+	}
+	et.collectUsages(inner)
+	p.collectUsages(et)
+
+	exits := et.scope.exits
+	if !inner.terminated {
+		exits = append(exits, inner.settings())
+	}
+	if _, isFor := loop.(*ast.ForStmt); !isFor || cond != nil {
+		// the body may not be executed at all
+		exits = append(exits, p.settings())
+	}
+
+	if len(exits) == 0 {
+		return
+	}
+	for _, s := range exits[1:] {
+		if !s.sameAs(exits[0]) {
+			return
+		}
+	}
+	p.applySettings(exits[0])
+}
+
+// parseSwitchScope parses a switch or a select, so an unlabeled break inside of it doesn't reach a loop
+func (p *execTrace) parseSwitchScope(label string, parseFn func(*execTrace)) {
+	et := p.spawn()
+	et.scope = &branchScope{label: label}
+	parseFn(et)
+	p.collectUsages(et)
+}
+
+// parseBranchStmt handles break, continue and goto, all of them end the current statement list
+func (p *execTrace) parseBranchStmt(op *ast.BranchStmt) {
+	label := ""
+	if op.Label != nil {
+		label = op.Label.Name
+	}
+
+	switch op.Tok {
+	case token.BREAK:
+		if scope := p.findScope(op.Tok, label); scope != nil && scope.loop {
+			scope.exits = append(scope.exits, p.settings())
+		}
+	case token.CONTINUE:
+		if scope := p.findScope(op.Tok, label); scope != nil {
+			scope.exits = append(scope.exits, p.settings())
+		}
+		p.terminated = true
+	case token.GOTO:
+		p.terminated = true
+		p.parseGoto(label)
+	case token.FALLTHROUGH:
+		// the next case is traced as a continuation of this one, see parseSwitch
+	}
+}
+
+// parseGoto postpones tracing of the label till the end of the func body, as a label is traced only once,
+// and it isn't traced by a goto when the normal flow reaches it
+func (p *execTrace) parseGoto(label string) {
+	if target := p.root().gotoTargets[label]; target != nil && !target.traced {
+		conds := map[*execTrace]ast.Expr{}
+		for et := p; et != nil; et = et.parent {
+			conds[et] = et.cond
+		}
+		target.gotos = append(target.gotos, gotoSource{p, conds})
+	}
+}
+
+// reachLabel marks the label as traced by the normal flow. Gotos to the label join the normal flow here,
+// so conditions narrowed after the first of them are dropped.
+func (p *execTrace) reachLabel(label string) {
+	target := p.root().gotoTargets[label]
+	if target == nil {
+		return
+	}
+	target.traced = true
+	if len(target.gotos) > 0 {
+		for et := p; et != nil; et = et.parent {
+			et.cond = target.gotos[0].conds[et]
+		}
+	}
+}
+
+// traceGotos traces statements from labels that are reached by gotos only. A label reached by a few gotos
+// is traced with conditions shared by all of them, and with their settings when all of them agree.
+func (p *execTrace) traceGotos() {
+	for {
+		var target *labelTarget
+		for _, t := range p.gotoTargets {
+			if !t.traced && len(t.gotos) > 0 && (target == nil || t.list[t.idx].Pos() < target.list[target.idx].Pos()) {
+				target = t
+			}
+		}
+		if target == nil {
+			return
+		}
+		target.traced = true
+
+		first := target.gotos[0]
+		from := first.et
+		sameSettings := true
+		for _, g := range target.gotos[1:] {
+			for !from.isAncestorOf(g.et) {
+				from = from.parent
+			}
+			sameSettings = sameSettings && g.et.settings().sameAs(first.et.settings())
+		}
+		for et := from; et != nil; et = et.parent {
+			et.cond = first.conds[et]
+		}
+
+		et := from.spawn()
+		if sameSettings {
+			et.applySettings(first.et.settings())
+		}
+		et.parseStatements(target.list[target.idx:])
+		p.collectUsages(et)
+	}
+}
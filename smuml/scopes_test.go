@@ -0,0 +1,109 @@
+package smuml
+
+import "testing"
+
+func TestLoopBranches(t *testing.T) {
+	const src = `
+type SMLoop struct {
+	smachine.StateMachineDeclTemplate
+	items []int
+}
+
+func (s *SMLoop) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	for _, it := range s.items {
+		if it == 0 {
+			continue
+		}
+		if it > 5 {
+			break
+		}
+		return ctx.Jump(s.stepNext)
+	}
+	return ctx.Stop()
+}
+
+func (s *SMLoop) stepNext(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	edges := renderEdges(t, src)
+	// continue and break terminate their branches, so the jump has both conditions negated
+	requireEdges(t, edges,
+		"Init --> stepNext : [it!=0 && it<=5]\\n<i>in loop</i>",
+		"Init --> [*]",
+	)
+	rejectEdges(t, edges, "Init --> stepNext : [it<=5]\\n<i>in loop</i>")
+}
+
+func TestGoto(t *testing.T) {
+	const src = `
+type SMGoto struct {
+	smachine.StateMachineDeclTemplate
+	c, d bool
+}
+
+func (s *SMGoto) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if s.c {
+		goto done
+	}
+	if s.d {
+		goto fail
+	}
+	ctx.Log()
+done:
+	return ctx.Jump(s.stepNext)
+fail:
+	return ctx.Error(nil)
+}
+
+func (s *SMGoto) stepNext(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	step := resolveSM(t, src, "SMGoto").Steps["Init"]
+	if len(step.Transitions) != 2 {
+		t.Fatalf("each label must be traced once: %+v", step.Transitions)
+	}
+
+	edges := renderEdges(t, src)
+	// done is reached by the goto and by the code before it
+	requireEdges(t, edges,
+		"Init --> stepNext",
+		"Init --[#red]> error : [!s.c && s.d]\\nError(nil)",
+	)
+	rejectEdges(t, edges,
+		"Init --> stepNext : [s.c]",
+		"Init --> stepNext : [!s.c && !s.d]",
+	)
+}
+
+func TestBreakOfSwitch(t *testing.T) {
+	const src = `
+type SMSwitch struct {
+	smachine.StateMachineDeclTemplate
+	c, d bool
+}
+
+func (s *SMSwitch) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	switch {
+	case s.c:
+		if s.d {
+			break
+		}
+		return ctx.Stop()
+	}
+	return ctx.Jump(s.stepNext)
+}
+
+func (s *SMSwitch) stepNext(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	edges := renderEdges(t, src)
+	// the case breaks out of the switch, so the code after it is reached from the case too
+	requireEdges(t, edges,
+		"Init --> [*] : [s.c && !s.d]",
+		"Init --> stepNext",
+	)
+	rejectEdges(t, edges, "Init --> stepNext : [!s.c]")
+}
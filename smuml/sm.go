@@ -0,0 +1,290 @@
+package smuml
+
+import (
+	"sort"
+	"strings"
+)
+
+// SMDecl is a model of SM type with its steps, transitions of steps are linked by FileSet.Resolve
+type SMDecl struct {
+	RType       string
+	Output      string
+	Package     string
+	SeqNo       int
+	Steps       map[string]*MethodDecl
+	HasDeclInit bool
+	TypeParams  []string
+	DeclType    string // a declaration type merged into this SM, e.g. dSMFoo
+	Hooks       *DeclHooks
+
+	embedResolved bool
+}
+
+// DeclHooks are optional methods of a declaration type that affect every step of the SM
+type DeclHooks struct {
+	ShadowMigrate   string // GetShadowMigrateFor, is called on every migration regardless of the current step
+	StepLogger      string // GetStepLogger
+	StepDeclaration string // GetStepDeclaration
+	IsConsecutive   bool   // IsConsecutive
+}
+
+// Title returns a name of SM type with type params of a generic type, e.g. SMFoo[T]
+func (p *SMDecl) Title() string {
+	if len(p.TypeParams) == 0 {
+		return p.RType
+	}
+	return p.RType + `[` + strings.Join(p.TypeParams, ", ") + `]`
+}
+
+func (p *SMDecl) addStep(step *MethodDecl, addUntyped bool) {
+	if p.Steps == nil {
+		p.Steps = map[string]*MethodDecl{}
+	}
+
+	if !addUntyped && step.MType == 0 {
+		return
+	}
+
+	if dup := p.findStep(step.Name); dup != nil {
+		dup.Duplicate = true
+		return
+	}
+
+	step.StepNo = 1 + len(p.Steps)
+
+	if step.MType == DeclarationInit {
+		p.HasDeclInit = true
+	}
+
+	p.Steps[step.Name] = step
+
+	for i := range step.SubSteps {
+		p.addStep(step.SubSteps[i], true)
+	}
+}
+
+// HasSyncOps returns true when a step of the SM operates on a sync object
+func (p *SMDecl) HasSyncOps() bool {
+	for _, step := range p.Steps {
+		if len(step.SyncOps) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// inherit adds steps promoted from an embedded SM. A base step overridden by this SM is kept under
+// a qualified name, as base steps refer to base methods - there is no virtual dispatch in Go.
+func (p *SMDecl) inherit(base *SMDecl) {
+	if p.Steps == nil {
+		p.Steps = map[string]*MethodDecl{}
+	}
+
+	overridden := map[string]string{}
+	for name := range base.Steps {
+		if own := p.Steps[name]; own != nil && own.InheritedFrom == "" {
+			overridden[name] = base.RType + `.` + name
+			own.Overrides = base.RType
+		}
+	}
+
+	baseNames := make([]string, 0, len(base.Steps))
+	for name := range base.Steps {
+		baseNames = append(baseNames, name)
+	}
+	sort.Strings(baseNames)
+
+	for _, name := range baseNames {
+		step := base.Steps[name].inheritedCopy(base.RType, overridden)
+		if qualified, ok := overridden[name]; ok {
+			step.Name = qualified
+			step.Shadowed = true
+		} else if p.Steps[name] != nil {
+			continue
+		}
+		step.StepNo = 1 + len(p.Steps)
+		p.Steps[step.Name] = step
+		if step.MType == DeclarationInit && !step.Shadowed {
+			p.HasDeclInit = true
+		}
+	}
+}
+
+// mergeDeclaration adds steps of the declaration type, e.g. GetInitStateFor, to the SM
+func (p *SMDecl) mergeDeclaration(decl *SMDecl) {
+	if p.Steps == nil {
+		p.Steps = map[string]*MethodDecl{}
+	}
+	p.DeclType = decl.RType
+
+	steps := make([]*MethodDecl, 0, len(decl.Steps))
+	for _, step := range decl.Steps {
+		steps = append(steps, step)
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].StepNo < steps[j].StepNo
+	})
+
+	for _, step := range steps {
+		if dup := p.Steps[step.Name]; dup != nil {
+			dup.Duplicate = true
+			continue
+		}
+		step.StepNo = 1 + len(p.Steps)
+		p.Steps[step.Name] = step
+		if step.MType == DeclarationInit {
+			p.HasDeclInit = true
+		}
+	}
+}
+
+// applyStepNames sets names given by StepDeclaration to steps of this SM, an inherited step gets
+// a name given for the embedded SM
+func (p *SMDecl) applyStepNames(names map[string]map[string]string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, step := range p.Steps {
+		rType := p.RType
+		if step.InheritedFrom != "" {
+			rType = step.InheritedFrom
+		}
+		if name := names[rType][strings.TrimPrefix(step.Name, rType+`.`)]; name != "" {
+			step.DeclName = name
+		}
+	}
+}
+
+func (p *SMDecl) findStep(name string) *MethodDecl {
+	if name == "" {
+		return nil
+	}
+	for {
+		if step := p.Steps[name]; step != nil {
+			return step
+		}
+		n := strings.IndexByte(name, '.')
+		if n < 0 {
+			return nil
+		}
+		name = name[n+1:]
+	}
+}
+
+// StartType returns a type of the first method of the SM, GetInitStateFor of a declaration goes before Init
+func (p *SMDecl) StartType() MethodType {
+	if p.HasDeclInit {
+		return DeclarationInit
+	}
+	return Initialization
+}
+
+// EntryStep returns the first step of the SM
+func (p *SMDecl) EntryStep() *MethodDecl {
+	startType := p.StartType()
+	var entry *MethodDecl
+	for _, step := range p.Steps {
+		if step.MType == startType && !step.Shadowed && (entry == nil || step.StepNo < entry.StepNo) {
+			entry = step
+		}
+	}
+	return entry
+}
+
+// resolveFields replaces transitions through fields of SM, e.g. ctx.Jump(s.onDone),
+// with indirect transitions to every method value assigned to the field.
+func (p *SMDecl) resolveFields(fields map[string][]string) {
+	if len(fields) == 0 {
+		return
+	}
+	for _, step := range p.Steps {
+		var transitions []MethodTransition
+		for _, tr := range step.Transitions {
+			var targets []string
+			if field := strings.TrimPrefix(tr.Transition, step.RName+`.`); step.RName != "" && len(field) < len(tr.Transition) {
+				targets = fields[field]
+			}
+			if len(targets) == 0 || p.findStep(tr.Transition) != nil {
+				transitions = append(transitions, tr)
+				continue
+			}
+			for _, target := range targets {
+				itr := tr
+				itr.Transition = target
+				itr.IndirectVia = tr.Transition
+				transitions = append(transitions, itr)
+			}
+		}
+		step.setTransitions(transitions)
+	}
+}
+
+func (p *SMDecl) propagate() {
+	for _, step := range p.Steps {
+		step.CanPropagate = false
+	}
+
+	for _, step := range p.Steps {
+		for i := range step.Transitions {
+			tr := &step.Transitions[i]
+			tr.TransitionTo = p.findStep(tr.Transition)
+			tr.HiddenPropTo = p.findStep(tr.HiddenPropagate)
+
+			for _, to := range []*MethodDecl{tr.TransitionTo, tr.HiddenPropTo} {
+				switch {
+				case to == nil:
+				case tr.ErrorHandler != "" && to.addErrorHandler(tr.ErrorHandler):
+					to.CanPropagate = true
+				}
+				switch {
+				case to == nil:
+				case tr.StepFlags != "" && to.addStepFlags(tr.StepFlags):
+					to.CanPropagate = true
+				}
+			}
+
+			if tr.Migration == "" {
+				continue
+			}
+
+			if tr.TransitionTo != nil && tr.TransitionTo.addMigration(tr.Migration) {
+				tr.TransitionTo.CanPropagate = true
+			}
+			if tr.HiddenPropTo != nil && tr.HiddenPropTo.addMigration(tr.Migration) {
+				tr.HiddenPropTo.CanPropagate = true
+			}
+
+			if migrationTo := p.findStep(tr.Migration); migrationTo != nil && migrationTo.addMigration(tr.Migration) {
+				migrationTo.CanPropagate = true
+			}
+		}
+	}
+
+	for {
+		didSomething := false
+		for _, step := range p.Steps {
+			if !step.CanPropagate {
+				continue
+			}
+			step.CanPropagate = false
+			for _, tr := range step.Transitions {
+				switch {
+				case tr.TransitionTo == nil:
+				case tr.TransitionTo.addDefaults(step, &tr):
+					tr.TransitionTo.CanPropagate = true
+					didSomething = true
+				}
+
+				switch {
+				case tr.HiddenPropTo == nil:
+				case tr.HiddenPropTo.addDefaults(step, nil):
+					tr.HiddenPropTo.CanPropagate = true
+					didSomething = true
+				}
+			}
+		}
+		if !didSomething {
+			return
+		}
+	}
+}
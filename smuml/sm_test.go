@@ -0,0 +1,151 @@
+package smuml
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStepFields(t *testing.T) {
+	const src = `
+type SMField struct {
+	smachine.StateMachineDeclTemplate
+	next  smachine.StateFunc
+	other struct{ next smachine.StateFunc }
+	ready bool
+}
+
+func (s *SMField) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	s.next = s.stepA
+	return ctx.Jump(s.stepDispatch)
+}
+
+func (s *SMField) stepDispatch(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	if s.ready {
+		return ctx.Jump(s.next)
+	}
+	if s.other.next != nil {
+		return ctx.Jump(s.other.next)
+	}
+	return ctx.Sleep().ThenRepeat()
+}
+
+func (s *SMField) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	step := resolveSM(t, src, "SMField").Steps["stepDispatch"]
+	var targets []string
+	for _, mt := range step.Transitions {
+		targets = append(targets, mt.Transition)
+	}
+	if len(targets) != 3 || targets[0] != "stepA" || targets[1] != "next" || targets[2] != "" {
+		t.Fatalf("unexpected transitions: %q", targets)
+	}
+	if step.Transitions[step.RepeatTrIdx].Transition != "" {
+		t.Errorf("repeat transition is lost: %d", step.RepeatTrIdx)
+	}
+
+	requireEdges(t, renderEdges(t, src), "stepDispatch --[#gray,dashed]> stepA : [s.ready]\\nvia s.next")
+}
+
+const testBaseSM = `
+type SMBase struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMBase) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMBase) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+
+func TestEmbeddingOnlySM(t *testing.T) {
+	src := testBaseSM + `
+type SMDerived struct {
+	SMBase
+}
+`
+	sm := resolveSM(t, src, "SMDerived")
+	for _, name := range []string{"Init", "stepWork"} {
+		if step := sm.Steps[name]; step == nil || step.InheritedFrom != "SMBase" {
+			t.Errorf("%s is not inherited: %+v", name, step)
+		}
+	}
+	requireEdges(t, renderEdges(t, src), "stepWork : inherited from SMBase")
+}
+
+func TestOverriddenStep(t *testing.T) {
+	// SMDerived goes first, so it is resolved before SMBase
+	src := `
+type SMDerived struct {
+	SMBase
+}
+
+func (s *SMDerived) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Jump(s.Init)
+}
+` + testBaseSM
+
+	sm := resolveSM(t, src, "SMDerived")
+	if own := sm.Steps["stepWork"]; own == nil || own.Overrides != "SMBase" {
+		t.Errorf("stepWork doesn't override: %+v", own)
+	}
+	base := sm.Steps["SMBase.stepWork"]
+	if base == nil || !base.Shadowed || base.Transitions[0].Transition != TerminalStop {
+		t.Errorf("unexpected overridden step: %+v", base)
+	}
+	if init := sm.Steps["Init"]; init == nil || init.Transitions[0].TransitionTo != base {
+		t.Errorf("inherited Init doesn't jump to the base step")
+	}
+}
+
+func TestSplitDeclaration(t *testing.T) {
+	const src = `
+type dSMFoo struct{ smachine.StateMachineDeclTemplate }
+
+var declSMFoo smachine.StateMachineDeclaration = &dSMFoo{}
+
+func (%[1]s) GetInitStateFor(sm smachine.StateMachine) smachine.InitFunc {
+	return sm.(*SMFoo).Init
+}
+
+func (%[1]s) GetShadowMigrateFor(sm smachine.StateMachine) smachine.ShadowMigrateFunc {
+	return sm.(*SMFoo).shadow
+}
+
+type SMFoo struct{}
+
+func (s *SMFoo) GetStateMachineDeclaration() smachine.StateMachineDeclaration {
+	return declSMFoo
+}
+
+func (s *SMFoo) shadow(mc smachine.MigrationContext, stable bool) {
+}
+
+func (s *SMFoo) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMFoo) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	for _, recv := range []string{"d *dSMFoo", "dSMFoo"} {
+		t.Run(recv, func(t *testing.T) {
+			src := fmt.Sprintf(src, recv)
+			sm := resolveSM(t, src, "SMFoo")
+			if sm.DeclType != "dSMFoo" || !sm.HasDeclInit || sm.Hooks == nil || sm.Hooks.ShadowMigrate != "shadow" {
+				t.Fatalf("declaration is not merged: %+v", sm)
+			}
+
+			requireEdges(t, renderEdges(t, src),
+				"GetInitStateFor : dSMFoo",
+				"[*] --> GetInitStateFor",
+				"GetInitStateFor --> Init",
+				"Init --> stepWork",
+				"stepWork : <size:10>shadow migration: shadow</size>",
+			)
+		})
+	}
+}
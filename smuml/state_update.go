@@ -0,0 +1,28 @@
+package smuml
+
+import (
+	"go/ast"
+)
+
+func newStateUpdate(parent *stateUpdate, name string) *stateUpdate {
+	return &stateUpdate{parent: parent, name: name, isContext: parent != nil && parent.isContext}
+}
+
+type stateUpdate struct {
+	parent    *stateUpdate
+	name      string
+	args      []ast.Expr
+	isContext bool
+	isCall    bool
+}
+
+func (u stateUpdate) fullName() string {
+	if u.parent == nil {
+		return u.name
+	}
+	return u.parent.fullName() + `.` + u.name
+}
+
+func (u *stateUpdate) hasName() bool {
+	return u != nil && u.name != ""
+}
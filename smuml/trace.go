@@ -0,0 +1,785 @@
+package smuml
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+var contextMarker = &stateUpdate{isContext: true}
+
+type execTrace struct {
+	md     *MethodDecl
+	parent *execTrace
+	fs     *sourceFile
+	traced map[string]*stateUpdate
+	usages map[string]int
+
+	cond    ast.Expr
+	alt     ast.Node // if or switch statement of the branch, to tell alternative branches
+	comment string
+
+	values        map[string][]stepValue // only at the root trace
+	pendingValues []resolvedValue
+	terminated    bool
+
+	migration    ast.Expr
+	errorHandler ast.Expr
+	stepFlags    ast.Expr
+
+	syncLinks         map[token.Pos]string // acquired sync objects by positions of their args
+	assignTo          string
+	terminationResult string
+
+	scope       *branchScope
+	gotoTargets map[string]*labelTarget // only at the root trace
+
+	deferred     []*ast.CallExpr
+	inDefer      bool
+	deferCounted map[*ast.CallExpr]bool // only at the root trace
+}
+
+func (p *execTrace) isTraced(n string) bool {
+	switch {
+	case p.traced != nil:
+		return p.traced[n] != nil
+	case p.parent != nil:
+		return p.parent.isTraced(n)
+	default:
+		return p.md.CtxArg == n
+	}
+}
+
+func (p *execTrace) getTraced(n string) *stateUpdate {
+	switch {
+	case p.traced != nil:
+		return p.traced[n]
+	case p.parent != nil:
+		return p.parent.getTraced(n)
+	case p.md.CtxArg == n:
+		return contextMarker
+	default:
+		return nil
+	}
+}
+
+func (p *execTrace) _copyInherited() map[string]*stateUpdate {
+	switch {
+	case p.traced != nil:
+		cp := make(map[string]*stateUpdate)
+		for k, v := range p.traced {
+			if v != nil {
+				cp[k] = v
+			}
+		}
+		return cp
+	case p.parent != nil:
+		return p.parent._copyInherited()
+	case p.md.CtxArg != "":
+		return map[string]*stateUpdate{p.md.CtxArg: contextMarker}
+	default:
+		return map[string]*stateUpdate{}
+	}
+}
+
+func (p *execTrace) setTraced(n string, upd *stateUpdate) {
+	if p.traced == nil {
+		if upd == nil && !p.isTraced(n) {
+			return
+		}
+		p.traced = p._copyInherited()
+	}
+
+	if upd == nil {
+		delete(p.traced, n)
+	} else {
+		p.traced[n] = upd
+	}
+}
+
+func (p *execTrace) remapContextNames(lhs []string, rhs []exprResult) {
+	switch {
+	case len(lhs) == 0:
+		return
+	case len(rhs) > 0:
+		hasRhs := false
+		for i, n := range lhs {
+			switch {
+			case n == "":
+				continue
+			case i >= len(rhs):
+				// multi-arg return from func
+				break
+			case rhs[i].name != nil:
+				if traced := p.getTraced(*rhs[i].name); traced != nil {
+					hasRhs = true
+					rhs[i].upd = traced
+				}
+			case n == p.md.UpdateArg && rhs[i].upd != nil && rhs[i].upd.isContext && rhs[i].upd.isCall:
+				// a named result, e.g. su = ctx.Jump(...)
+				hasRhs = true
+			}
+		}
+		if !hasRhs {
+			break
+		}
+
+		for i, n := range lhs {
+			switch {
+			case n == "":
+				continue
+			case len(rhs) == 1:
+				// multi-arg return from func
+				p.setTraced(n, rhs[0].upd)
+			default:
+				p.setTraced(n, rhs[i].upd)
+			}
+		}
+		return
+	}
+
+	for _, n := range lhs {
+		if n != "" {
+			p.setTraced(n, nil)
+		}
+	}
+}
+
+func (p *execTrace) spawn() *execTrace {
+	return &execTrace{md: p.md, parent: p, fs: p.fs, migration: p.migration, errorHandler: p.errorHandler, stepFlags: p.stepFlags,
+		deferred: p.deferred[:len(p.deferred):len(p.deferred)]}
+}
+
+func (p *execTrace) spawnCond(cond ast.Expr, comment string) *execTrace {
+	et := p.spawn()
+	et.cond = cond
+	et.comment = comment
+	return et
+}
+
+// nearestComment returns a comment of the innermost branch, a nested branch without a comment keeps its condition
+func (p *execTrace) nearestComment() string {
+	for et := p; et != nil; et = et.parent {
+		switch {
+		case et.comment != "":
+			return et.comment
+		case et.alt != nil:
+			return ""
+		}
+	}
+	return ""
+}
+
+// parseBranch parses statements of a conditional branch, settings made inside of the branch don't leak out
+func (p *execTrace) parseBranch(alt ast.Node, cond ast.Expr, comment string, list []ast.Stmt) {
+	if len(list) == 0 {
+		return
+	}
+	et := p.spawnCond(cond, comment)
+	et.alt = alt
+	et.parseStatements(list)
+	p.collectUsages(et)
+}
+
+// parseSwitch parses cases as alternative branches, a case of a switch without a tag is reached
+// only when preceding cases don't match. A case of a sole fallthrough is joined to the next one,
+// e.g. `case A: fallthrough; case B:` is parsed as `case A, B:`, and other cases ending with fallthrough
+// go on with statements of the next one.
+func (p *execTrace) parseSwitch(cases switchCases, body *ast.BlockStmt) {
+	if body == nil {
+		return
+	}
+	first, comment := -1, ""
+	for i, stmt := range body.List {
+		cc, ok := stmt.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if comment == "" {
+			comment = p.fs.commentOf(cc)
+		}
+		if len(cc.Body) == 1 && isFallthrough(cc.Body[0]) {
+			continue
+		}
+		p.parseBranch(body, cases.rangeCond(body.List, first, i), comment, fallthroughBody(body.List[i:]))
+		first, comment = -1, ""
+	}
+}
+
+// fallthroughBody returns statements of the first clause followed by statements of the next ones it falls through to
+func fallthroughBody(clauses []ast.Stmt) []ast.Stmt {
+	var list []ast.Stmt
+	for _, cl := range clauses {
+		cc, ok := cl.(*ast.CaseClause)
+		if !ok {
+			break
+		}
+		list = append(list[:len(list):len(list)], cc.Body...)
+		if n := len(list); n == 0 || !isFallthrough(list[n-1]) {
+			break
+		}
+		list = list[:len(list)-1]
+	}
+	return list
+}
+
+func isFallthrough(stmt ast.Stmt) bool {
+	op, ok := stmt.(*ast.BranchStmt)
+	return ok && op.Tok == token.FALLTHROUGH
+}
+
+func (p *execTrace) parseBlockStmt(stmt *ast.BlockStmt) {
+	if stmt == nil {
+		return
+	}
+	p.parseStatements(stmt.List)
+}
+
+func (p *execTrace) parseStatements(list []ast.Stmt) {
+	if len(list) == 0 {
+		return
+	}
+
+	et := p.spawn()
+	su := et._parseStatements(list)
+	if su != nil {
+		et.addExit(su)
+	}
+
+	p.collectUsages(et)
+	p.migration, p.errorHandler, p.stepFlags = et.migration, et.errorHandler, et.stepFlags
+	p.deferred = et.deferred
+}
+
+func (p *execTrace) collectUsages(from *execTrace) {
+	for k, n := range from.usages {
+		if p.usages == nil {
+			p.usages = make(map[string]int)
+		}
+		p.usages[k] += n
+	}
+}
+
+func (p *execTrace) _parseDecl(decl *ast.GenDecl) {
+	switch decl.Tok {
+	case token.CONST:
+		for _, spec := range decl.Specs {
+			vSpec := spec.(*ast.ValueSpec)
+			for _, n := range vSpec.Names {
+				p.setTraced(n.Name, nil)
+			}
+		}
+	case token.VAR:
+		for _, spec := range decl.Specs {
+			vSpec := spec.(*ast.ValueSpec)
+			lhs := make([]ast.Expr, len(vSpec.Names))
+			for i, n := range vSpec.Names {
+				lhs[i] = n
+				p.untrackValue(n.Name)
+			}
+			p.trackValues(lhs, vSpec.Values)
+			p.remapContextNames(p.identToNames(vSpec.Names), p.exprToValues(vSpec.Values))
+		}
+	case token.TYPE:
+		for _, spec := range decl.Specs {
+			vSpec := spec.(*ast.TypeSpec)
+			if vSpec.Name != nil {
+				p.setTraced(vSpec.Name.Name, nil)
+			}
+		}
+	}
+}
+
+func (p *execTrace) _parseStatements(list []ast.Stmt) *stateUpdate {
+	for _, stmt := range list {
+		if p.applyDirectives(stmt) {
+			continue
+		}
+		label := ""
+		for {
+			switch op := stmt.(type) {
+			case *ast.DeclStmt:
+				switch decl := op.Decl.(type) {
+				case *ast.GenDecl:
+					p._parseDecl(decl)
+				case *ast.FuncDecl:
+					// skip
+				}
+			case *ast.LabeledStmt:
+				if op.Label != nil {
+					label = op.Label.Name
+					p.reachLabel(label)
+				}
+				stmt = op.Stmt
+				continue
+			case *ast.ExprStmt:
+				if call, ok := op.X.(*ast.CallExpr); ok {
+					if id, ok := call.Fun.(*ast.Ident); ok && id.Name == "panic" {
+						p.comment = p.fs.commentOf(op)
+						p.terminated = true
+						p.addPanic(call.Args)
+						return nil
+					}
+				}
+				p.parseCallToCtx(op.X)
+			case *ast.AssignStmt:
+				for i, rhs := range op.Rhs {
+					if call, ok := rhs.(*ast.CallExpr); ok {
+						if len(op.Lhs) == len(op.Rhs) {
+							p.assignTo = p.fs.excerpt(op.Lhs[i].Pos(), op.Lhs[i].End(), p.labels().MaxKeyLen)
+						}
+						p.parseCallToCtx(call)
+						p.assignTo = ""
+					}
+				}
+				p.trackValues(op.Lhs, op.Rhs)
+				p.remapContextNames(p.exprToNames(op.Lhs), p.exprToValues(op.Rhs))
+				p.addDeferredAssignment(op.Lhs)
+			case *ast.ReturnStmt:
+				p.comment = p.fs.commentOf(op)
+				p.terminated = true
+				for _, result := range op.Results {
+					// usages are counted as of an assigned call
+					if call, ok := result.(*ast.CallExpr); ok {
+						p.parseCallToCtx(call)
+					}
+				}
+				switch {
+				case p.isInDefer():
+					// end of a deferred func
+				case len(op.Results) == 0:
+					// named return params
+					if p.md.UpdateArg != "" {
+						return p.getTraced(p.md.UpdateArg)
+					}
+				case p.md.UpdateIdx == 0:
+					// this is a non-context func
+					return p.exprToResult(op.Results[0])
+				case p.md.UpdateIdx > len(op.Results):
+				default:
+					return p.exprToResult(op.Results[p.md.UpdateIdx-1])
+				}
+				return nil
+
+			case *ast.BranchStmt:
+				p.parseBranchStmt(op)
+				return nil
+			case *ast.BlockStmt:
+				p.parseStatements(op.List)
+			case *ast.IfStmt:
+				p.parseCallsIn(op.Init)
+				p.parseCallsIn(op.Cond)
+				if op.Body != nil {
+					p.parseBranch(op, op.Cond, p.fs.commentOf(op), op.Body.List)
+				}
+				if op.Else != nil {
+					p.parseBranch(op, negateCond(op.Cond), p.fs.commentOf(op.Else), []ast.Stmt{op.Else})
+				}
+				if cond := passCond(op); cond != nil {
+					p.cond = conjoinCond(p.cond, cond)
+				}
+			case *ast.CaseClause:
+				// handled by parseSwitch
+			case *ast.SwitchStmt:
+				p.parseCallsIn(op.Init)
+				p.parseCallsIn(op.Tag)
+				cases := switchCases{tag: op.Tag}
+				p.parseSwitchScope(label, func(et *execTrace) {
+					et.parseSwitch(cases, op.Body)
+				})
+				if cases.allTerminate(op.Body, label) {
+					p.cond = conjoinCond(p.cond, cases.notCond(op.Body.List))
+				}
+			case *ast.TypeSwitchStmt:
+				cases := switchCases{tag: getTypeSwitchTag(op.Assign), isType: true}
+				p.parseSwitchScope(label, func(et *execTrace) {
+					et.parseSwitch(cases, op.Body)
+				})
+				if cases.allTerminate(op.Body, label) {
+					p.cond = conjoinCond(p.cond, cases.notCond(op.Body.List))
+				}
+			case *ast.CommClause:
+				body := op.Body
+				switch {
+				case op.Comm == nil:
+				case len(body) == 0:
+					body = []ast.Stmt{op.Comm}
+				default:
+					body = make([]ast.Stmt, 1, len(op.Body)+1)
+					body[0] = op.Comm
+					body = append(body, op.Body...)
+				}
+				p.parseStatements(body)
+			case *ast.SelectStmt:
+				p.parseSwitchScope(label, func(et *execTrace) {
+					et.parseBlockStmt(op.Body)
+				})
+			case *ast.ForStmt:
+				p.parseCallsIn(op.Init)
+				p.parseCallsIn(op.Cond)
+				p.parseLoop(op, label, op.Cond, op.Body)
+			case *ast.RangeStmt:
+				p.parseCallsIn(op.X)
+				p.parseLoop(op, label, nil, op.Body)
+			case *ast.DeferStmt:
+				if op.Call != nil {
+					p.deferred = append(p.deferred, op.Call)
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+type exprResult struct {
+	name *string
+	upd  *stateUpdate
+}
+
+func (p *execTrace) exprToNames(exprs []ast.Expr) []string {
+	if len(exprs) == 0 {
+		return nil
+	}
+
+	s := make([]string, len(exprs))
+	count := 0
+	for i, ex := range exprs {
+		switch x, sel := getSelectorOfExpr(ex); {
+		case x != "":
+		case sel == "":
+		default:
+			count++
+			s[i] = sel
+		}
+	}
+
+	if count == 0 {
+		return nil
+	}
+	return s
+}
+
+func (p *execTrace) exprToResult(expr ast.Expr) *stateUpdate {
+	switch mt := p.md.MType; {
+	case mt.HasStateUpdate():
+	case mt.HasContextArg():
+		if op, ok := expr.(*ast.UnaryExpr); ok {
+			if op.Op != token.AND {
+				break
+			}
+			expr = op.X
+		}
+		if op, ok := expr.(*ast.CompositeLit); ok {
+			sel := p.getInlineFuncExpr(op, 0)
+			if sel != "" {
+				return newStateUpdate(nil, sel)
+			}
+		}
+	}
+	return p.exprToValue(expr)
+}
+
+func (p *execTrace) exprToValue(expr ast.Expr) *stateUpdate {
+	switch arg := expr.(type) {
+	case *ast.SelectorExpr:
+		sel := ""
+		if arg.Sel != nil {
+			sel = arg.Sel.Name
+		}
+		if parent := p.exprToValue(arg.X); parent != nil {
+			return newStateUpdate(parent, sel)
+		}
+		return nil
+	case *ast.CallExpr:
+		call := p.exprToValue(arg.Fun)
+		// TODO check for context in args
+		if call != nil {
+			call.isCall = true
+			call.args = arg.Args
+		}
+		return call
+	case *ast.TypeAssertExpr:
+		// sm.(*SMFoo).Init
+		return p.exprToValue(arg.X)
+	case *ast.Ident:
+		su := p.getTraced(arg.Name)
+		if su != nil {
+			return su
+		}
+		return &stateUpdate{name: arg.Name}
+	}
+	return nil
+}
+
+func (p *execTrace) parseCallToCtx(expr ast.Expr) {
+	arg, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return
+	}
+
+	call := p.exprToValue(arg.Fun)
+	switch {
+	case call == nil:
+		return
+	case call.parent == contextMarker:
+		p.parseContextCall(call.name, arg.Args)
+		return
+	case p.op(call.name).Exchange == "use" && p.hasContextArg(arg.Args) >= 0:
+		// SharedDataAccessor.TryUse(ctx)
+		p.addUsage(call.name)
+		p.addSharedAccess(call.name, call.parent)
+		return
+	case call.parent != nil && call.parent.parent == contextMarker && p.op(call.parent.name).Role == OpBargeIn:
+		// ctx.NewBargeIn().WithJump(...)
+		p.addUsage(call.parent.name)
+		if spec := p.op(call.name); spec.Then {
+			p.addBargeIn(spec, call.name, arg.Args)
+		}
+		return
+	}
+
+	// a chained call, e.g. ctx.Log().Trace(...) or ctx.Acquire(link).IsNotPassed()
+	for su := call.parent; su.hasName(); su = su.parent {
+		if su.parent == contextMarker {
+			p.parseContextCall(su.name, su.args)
+			return
+		}
+	}
+	p.lookForAdapterCall(call)
+}
+
+func (p *execTrace) parseContextCall(name string, args []ast.Expr) {
+	spec := p.op(name)
+	if !p.isInDefer() || !spec.Role.buildsUpdate() {
+		// a StateUpdate made by a deferred call is either a result or is dropped
+		p.addUsage(name)
+	}
+
+	switch spec.Role {
+	case OpChild:
+		p.addChild(spec, name, args)
+	case OpExchange:
+		p.addExchange(spec, name, args)
+	case OpBargeIn:
+		if !spec.Then {
+			p.addBargeIn(spec, name, args)
+		}
+	case OpSyncAcquire:
+		if arg := spec.ArgOf(args); arg != nil {
+			if p.syncLinks == nil {
+				p.syncLinks = map[token.Pos]string{}
+			}
+			p.syncLinks[arg.Pos()] = p.addSyncOp(name, arg)
+		} else {
+			p.addSyncOp(name, nil)
+		}
+	case OpSyncRelease:
+		p.addSyncOp(name, spec.ArgOf(args))
+	case OpSetter:
+		if arg := spec.ArgOf(args); arg != nil {
+			p.setDefault(spec.Setting, arg)
+		}
+	}
+}
+
+// op returns a spec of the context method by the API version of the file, and warns when the method is deprecated
+func (p *execTrace) op(name string) OpSpec {
+	spec := p.fs.ops.Lookup(name)
+	if spec.Deprecated != "" {
+		p.fs.fs.warn(fmt.Sprintf("%s: %s.%s uses %s, deprecated in %s API: %s",
+			p.fs.filename, p.md.RType, p.md.Name, name, p.fs.api, spec.Deprecated))
+	}
+	return spec
+}
+
+func (p *execTrace) addUsage(name string) {
+	if p.usages == nil {
+		p.usages = make(map[string]int)
+	}
+	p.usages[name]++
+}
+
+// parseCallsIn looks for calls to context inside of an expression or a simple statement, e.g. in a condition
+func (p *execTrace) parseCallsIn(node ast.Node) {
+	if node == nil {
+		return
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch op := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			// inner calls of a chain are handled by parseCallToCtx
+			p.parseCallToCtx(op)
+			for _, arg := range op.Args {
+				p.parseCallsIn(arg)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// gatingSyncLink returns a sync object acquired by a condition of this or an enclosing branch,
+// e.g. `if ctx.Acquire(s.sync).IsNotPassed() { return ctx.Sleep().ThenRepeat() }`
+func (p *execTrace) gatingSyncLink() string {
+	for et := p; et != nil; et = et.parent {
+		if et.cond == nil {
+			continue
+		}
+		if link := p.findGatingAcquire(et.cond, false); link != "" {
+			return link
+		}
+	}
+	return ""
+}
+
+// findGatingAcquire looks for an acquire that is not passed when the condition is true
+func (p *execTrace) findGatingAcquire(cond ast.Expr, negated bool) string {
+	switch op := cond.(type) {
+	case *ast.ParenExpr:
+		return p.findGatingAcquire(op.X, negated)
+	case *ast.UnaryExpr:
+		if op.Op == token.NOT {
+			return p.findGatingAcquire(op.X, !negated)
+		}
+	case *ast.BinaryExpr:
+		if op.Op == token.LAND || op.Op == token.LOR {
+			if link := p.findGatingAcquire(op.X, negated); link != "" {
+				return link
+			}
+			return p.findGatingAcquire(op.Y, negated)
+		}
+	case *ast.CallExpr:
+		sel, ok := op.Fun.(*ast.SelectorExpr)
+		switch {
+		case !ok:
+		case sel.Sel.Name == "IsNotPassed" && !negated, sel.Sel.Name == "IsPassed" && negated:
+			link := ""
+			ast.Inspect(sel.X, func(n ast.Node) bool {
+				if expr, ok := n.(ast.Expr); ok && link == "" {
+					link = p.syncLinkAt(expr.Pos())
+				}
+				return link == ""
+			})
+			return link
+		}
+	}
+	return ""
+}
+
+func (p *execTrace) syncLinkAt(pos token.Pos) string {
+	for et := p; et != nil; et = et.parent {
+		if link, ok := et.syncLinks[pos]; ok {
+			return link
+		}
+	}
+	return ""
+}
+
+func (p *execTrace) nearestTerminationResult() string {
+	for et := p; et != nil; et = et.parent {
+		if et.terminationResult != "" {
+			return et.terminationResult
+		}
+	}
+	return ""
+}
+
+func (p *execTrace) setDefault(setting string, arg ast.Expr) {
+	switch setting {
+	case "migration":
+		p.migration = arg
+	case "flags":
+		p.stepFlags = arg
+	case "handler":
+		p.errorHandler = arg
+	case "result":
+		p.terminationResult = p.shortenArgs([]ast.Expr{arg}, p.labels().MaxArgLen)
+	}
+}
+
+func (p *execTrace) lookForAdapterCall(su *stateUpdate) {
+	if len(su.args) != 0 {
+		return
+	}
+
+	if p.op(su.name).Role != OpAdapter {
+		return
+	}
+
+	if prep, adapter := p._extractAdapterCall(su); prep != nil {
+		p.addAdapterCall(prep, adapter)
+	}
+}
+
+func (p *execTrace) _extractAdapterCall(su *stateUpdate) (*stateUpdate, *stateUpdate) {
+	top := su
+
+	for su = su.parent; su.hasName(); su = su.parent {
+		if p.op(su.name).Role == OpAdapterPrep {
+			if p.hasContextArg(su.args) >= 0 {
+				return top, su
+			}
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *execTrace) hasContextArg(args []ast.Expr) int {
+	for i, arg := range args {
+		if p.isContextArg(arg) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *execTrace) isContextArg(arg ast.Expr) bool {
+	return p.exprToValue(arg) == contextMarker
+}
+
+func (p *execTrace) exprToValues(exprs []ast.Expr) []exprResult {
+	if len(exprs) == 0 {
+		return nil
+	}
+	s := make([]exprResult, len(exprs))
+	count := 0
+	for i, expr := range exprs {
+		su := p.exprToValue(expr)
+		if su != nil {
+			count++
+			s[i].upd = su
+			continue
+		}
+
+		switch x, sel := getSelectorOfExpr(expr); {
+		case x != "":
+		case sel == "":
+		default:
+			count++
+			s[i].name = &sel
+		}
+	}
+
+	if count == 0 {
+		return nil
+	}
+	return s
+}
+
+func (p *execTrace) identToNames(names []*ast.Ident) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	s := make([]string, len(names))
+	for i, n := range names {
+		s[i] = n.Name
+	}
+	return s
+}
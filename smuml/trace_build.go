@@ -0,0 +1,812 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+func (p *execTrace) addTransition(su *stateUpdate) {
+	mt := MethodTransition{}
+	mt.Condition, mt.FullCondition = p.buildCondition()
+	mt.Comment = p.nearestComment()
+	mt.Repeatable = p.inLoop()
+	if p.migration != nil {
+		mt.Migration = p.getInlineFuncExpr(p.resolveSingleValue(p.migration), Execution)
+	} else {
+		mt.InheritMigration = true
+	}
+	if p.errorHandler != nil {
+		mt.ErrorHandler = p.getInlineFuncExpr(p.errorHandler, Failure)
+	} else {
+		mt.InheritErrorHandler = true
+	}
+	if p.stepFlags != nil {
+		mt.StepFlags = getStepFlagNames(p.stepFlags)
+	} else {
+		mt.InheritStepFlags = true
+	}
+
+	switch p.md.MType {
+	case DeclarationInit, Construction:
+		mt.Transition = su.fullName()
+	case 0:
+		fallthrough
+	default:
+		if !p.addContextOpTransition(su, &mt) {
+			if mt.Transition == "" {
+				return
+			}
+			mt.Transition = "<unknown>"
+		}
+	}
+
+	var waitFor []ast.Expr
+	if mt.WaitTransition {
+		if mt.SyncLink == "" {
+			mt.SyncLink = p.gatingSyncLink()
+		}
+		if mt.SyncLink != "" {
+			waitFor = []ast.Expr{ast.NewIdent(`wait for ` + mt.SyncLink)}
+			mt.Condition, mt.FullCondition = p.buildConditionWith(waitFor)
+		}
+	}
+
+	if values := p.pendingValues; len(values) > 0 {
+		// a jump through a local variable, there is a transition per each possible value
+		p.pendingValues = nil
+		for _, v := range values {
+			vt := mt
+			p.getStepValueExpr(v.expr).applyTo(&vt)
+			vt.Condition, vt.FullCondition = p.buildConditionWith(append(v.conds[:len(v.conds):len(v.conds)], waitFor...))
+			p.md.addTransition(vt)
+		}
+		return
+	}
+	p.md.addTransition(mt)
+}
+
+// usePendingValues returns true when the arg is a local variable with known values, these are expanded by addTransition
+func (p *execTrace) usePendingValues(arg ast.Expr, mt *MethodTransition) bool {
+	p.pendingValues = p.resolveValues(arg)
+	if len(p.pendingValues) == 0 {
+		return false
+	}
+	mt.Transition = "<values>"
+	return true
+}
+
+func (p *execTrace) resolveSingleValue(expr ast.Expr) ast.Expr {
+	if values := p.resolveValues(expr); len(values) == 1 {
+		return values[0].expr
+	}
+	return expr
+}
+
+func (p *execTrace) getStepValueExpr(expr ast.Expr) slotStep {
+	x := expr
+	if op, ok := x.(*ast.UnaryExpr); ok && op.Op == token.AND {
+		x = op.X
+	}
+	if _, ok := x.(*ast.CompositeLit); ok {
+		return p.getSlotStepExpr(x)
+	}
+	return slotStep{transition: p.getInlineFuncExpr(expr, Execution)}
+}
+
+func (p *execTrace) addContextOpTransition(su *stateUpdate, mt *MethodTransition) bool {
+	switch spec := p.op(su.name); spec.Role {
+	case OpSubroutine:
+		if len(su.args) != 3 {
+			return false
+		}
+		// CallSubroutine(SubroutineStateMachine, MigrateFunc, SubroutineExitFunc) StateUpdate
+		//
+		// this step -> SubroutineSM -> SubroutineExitFunc
+
+		mt.Operation = su.name
+		if mh := p.getInlineFuncExpr(su.args[1], Migration); mh != "" {
+			mt.Migration = mh
+			mt.InheritMigration = false
+		}
+		mt.Transition = p.md.Name + `.` + p.getInlineFuncExpr(su.args[0], 0) + `.` + strconv.Itoa(len(p.md.SubSteps)+1)
+
+		mds := p.buildSubStep(mt.Transition, nil, 0)
+		mds.addMigration(mt.Migration)
+		mds.IsSubroutine = true
+
+		exitStep := p.getInlineFuncExpr(su.args[2], Execution) // net exactly an execution, but ok
+		mds.addTransition(MethodTransition{
+			Transition: exitStep,
+		})
+
+		mt.Migration = ""             // Migration from CallSubroutine is not applied to the caller
+		mt.HiddenPropagate = exitStep // all settings applied to the next step after SM return
+
+		return true
+
+	case OpTerminal:
+		mt.InheritMigration = false
+		switch spec.Terminal {
+		case "error":
+			mt.Operation = su.name + `(` + p.shortenArgs(su.args, p.labels().MaxArgLen) + `)`
+			mt.Transition = TerminalError
+		case "replace":
+			arg := spec.ArgOf(su.args)
+			if arg == nil {
+				return false
+			}
+			replace := MethodTransition{Transition: TerminalReplace, Operation: "Replace"}
+			if replacement := p.getReplacementType(spec, arg); replacement != "" {
+				replace.Operation += `: ` + replacement
+			}
+			if _, ok := arg.(*ast.FuncLit); ok {
+				// CreateFunc is traced as a construction step that ends with the replacement
+				if sub := p.md.findSubStep(p.getInlineFuncExpr(arg, Construction)); sub != nil {
+					for _, tr := range sub.Transitions {
+						// the created SM is shown by the replace terminal
+						sub.removeSubStep(tr.Transition)
+					}
+					sub.setTransitions([]MethodTransition{replace})
+					mt.Operation = su.name
+					mt.Transition = sub.Name
+					mt.InheritMigration = true
+					return true
+				}
+			}
+			mt.Operation, mt.Transition = replace.Operation, replace.Transition
+		default:
+			mt.Transition = TerminalStop
+			mt.Result = p.nearestTerminationResult()
+		}
+		return true
+
+	case OpStay:
+		mt.Operation = ""
+		return false
+
+	case OpUnsupported:
+		mt.Operation, mt.DelayedStart = p.buildOperation(su.parent)
+		mt.Transition = "<" + su.name + ">"
+		return false
+
+	case OpRepeatJump:
+		mt.Operation, mt.DelayedStart = p.buildOperation(su.parent)
+		if len(su.args) == 0 {
+			return false
+		}
+		p.md.addTransition(*mt) // adds a repeat transition, because mt.Transition is empty
+		return p.jumpTo(spec, su.args, mt)
+
+	case OpRepeat:
+		if len(su.args) == 0 {
+			return false
+		}
+		mt.Operation = su.name + `(` + p.shortenArgs(su.args, p.labels().MaxArgLen) + `)`
+		return true
+
+	case OpRestore:
+		mt.WaitTransition = true
+		mt.Operation = su.name
+		return p.jumpTo(spec, su.args, mt)
+
+	default:
+		if spec.Then {
+			switch parent := p.op(su.parent.name); parent.Role {
+			case OpWait:
+				mt.WaitTransition = true
+			case OpSyncAcquire:
+				// ctx.Acquire(link).ThenRepeat() waits for the acquired object
+				mt.WaitTransition = true
+				if arg := parent.ArgOf(su.parent.args); arg != nil {
+					mt.SyncLink = p.getSyncLinkName(arg)
+				}
+			}
+			mt.Operation, mt.DelayedStart = p.buildOperation(su.parent)
+		}
+		if len(su.args) == 0 && !spec.SlotStep { // repeat and similar ops
+			return true
+		}
+		return p.jumpTo(spec, su.args, mt)
+	}
+}
+
+// jumpTo sets a target of the transition by the main argument of the operation, it is either StateFunc or SlotStep
+func (p *execTrace) jumpTo(spec OpSpec, args []ast.Expr, mt *MethodTransition) bool {
+	arg := spec.ArgOf(args)
+	switch {
+	case arg == nil:
+		return false
+	case p.usePendingValues(arg, mt):
+		return true
+	case spec.SlotStep:
+		p.getSlotStepExpr(arg).applyTo(mt)
+	default:
+		mt.Transition = p.getInlineFuncExpr(arg, Execution)
+	}
+	return mt.Transition != ""
+}
+
+// slotStep is a content of smachine.SlotStep{Transition, Migration, Handler, Flags}
+type slotStep struct {
+	transition string
+	migration  string
+	handler    string
+	flags      string
+}
+
+var slotStepFields = []string{"Transition", "Migration", "Handler", "Flags"}
+
+func (v slotStep) applyTo(mt *MethodTransition) {
+	mt.Transition = v.transition
+	if v.migration != "" {
+		mt.Migration = v.migration
+		mt.InheritMigration = false
+	}
+	mt.SlotHandler = v.handler
+	mt.SlotFlags = v.flags
+}
+
+func (p *execTrace) getSlotStepExpr(expr ast.Expr) (result slotStep) {
+	switch op := expr.(type) {
+	case *ast.StarExpr:
+		// a pointer to SlotStep, e.g. ctx.JumpExt(*step)
+		if v := p.resolveSingleValue(op.X); v != op.X {
+			return p.getStepValueExpr(v)
+		}
+	case *ast.Ident:
+		if v := p.resolveSingleValue(op); v != ast.Expr(op) {
+			return p.getStepValueExpr(v)
+		}
+	case *ast.CompositeLit:
+		if _, sel := getSelectorOfExpr(op.Type); sel != "SlotStep" {
+			break
+		}
+
+		for i, el := range op.Elts {
+			key, value := "", el
+			if kve, ok := el.(*ast.KeyValueExpr); ok {
+				if xkey, k := getSelectorOfExpr(kve.Key); xkey == "" {
+					key = k
+				}
+				value = kve.Value
+			} else if i < len(slotStepFields) {
+				key = slotStepFields[i]
+			}
+
+			switch key {
+			case "Transition":
+				result.transition = p.getInlineFuncExpr(value, Execution)
+			case "Migration":
+				result.migration = p.getInlineFuncExpr(value, Migration)
+			case "Handler":
+				result.handler = p.getInlineFuncExpr(value, Failure)
+			case "Flags":
+				result.flags = getStepFlagNames(value)
+			}
+		}
+		return
+	}
+
+	_, sel := getSelectorOfExpr(unstarExpr(expr))
+	return slotStep{transition: "DYNAMIC " + sel}
+}
+
+func (p *execTrace) buildSubStep(name string, args *ast.FieldList, mType MethodType) *MethodDecl {
+	md := &MethodDecl{
+		SM:    p.md.SM,
+		RType: p.md.RType,
+		RName: p.md.RName,
+		Name:  name,
+		MType: mType,
+	}
+
+	if mType.HasContextArg() && args != nil {
+		if mt, argName := p.fs.findContextArg(args.List); mt == mType {
+			md.CtxArg = argName
+		}
+	}
+
+	if mType.HasStateUpdate() {
+		md.UpdateIdx = 1
+	}
+	p.md.SubSteps = append(p.md.SubSteps, md)
+	return md
+}
+
+func (p *execTrace) getInlineFuncExpr(expr ast.Expr, mType MethodType) string {
+	switch op := expr.(type) {
+	case *ast.UnaryExpr:
+		if mType.HasStateUpdate() {
+			break
+		}
+
+		if op.Op == token.AND {
+			return p.getInlineFuncExpr(op.X, mType)
+		}
+	case *ast.CompositeLit:
+		if mType != 0 {
+			break
+		}
+
+		x, sel := getSelectorOfExpr(op.Type)
+		if x != "" {
+			sel = x + `.` + sel + `{}`
+		} else {
+			sel += `{}`
+		}
+		mds := p.buildSubStep(sel, nil, mType)
+		mds.IsSubroutine = true
+		return sel
+
+	case *ast.FuncLit:
+		if funcName := p.md.inlined[op]; funcName != "" {
+			return funcName
+		}
+		funcName := p.md.inlineFuncName(op)
+		mds := p.buildSubStep(funcName, op.Type.Params, mType)
+		mds.parseFuncBody(op.Body, p.fs)
+
+		if p.md.inlined == nil {
+			p.md.inlined = map[*ast.FuncLit]string{}
+		}
+		p.md.inlined[op] = funcName
+		return funcName
+	}
+
+	switch x, sel := getSelectorOfExpr(expr); {
+	case x != "":
+		return x + `.` + sel
+	case sel != "nil":
+		return sel
+	}
+	return ""
+}
+
+// buildCondition builds a full path condition of the trace - all enclosing conditions are conjoined.
+// Returns both abbreviated and full source text of the condition.
+func (p *execTrace) buildCondition() (cond, fullCond string) {
+	return p.buildConditionWith(nil)
+}
+
+// buildConditionWith builds a full path condition with extra conditions added
+func (p *execTrace) buildConditionWith(extra []ast.Expr) (cond, fullCond string) {
+	conds := append(p.pathConds(), extra...)
+	terms, ok := simplifyConds(conds)
+	if !ok {
+		// a contradictory path is shown as is
+		terms = nil
+		for _, c := range conds {
+			terms = splitConjunction(c, terms)
+		}
+	}
+	if len(terms) == 0 {
+		return "", ""
+	}
+
+	maxLen := p.labels().MaxCondLen
+	b, fb := strings.Builder{}, strings.Builder{}
+	seen := map[string]bool{}
+	for _, t := range terms {
+		cs, fs := p.shortenCond(t, maxLen), types.ExprString(t)
+		if len(terms) > 1 && isDisjunction(t) {
+			cs, fs = `(`+cs+`)`, `(`+fs+`)`
+		}
+		if seen[fs] {
+			continue
+		}
+		seen[fs] = true
+		if b.Len() > 0 {
+			b.WriteString(" && ")
+			fb.WriteString(" && ")
+		}
+		b.WriteString(cs)
+		fb.WriteString(fs)
+	}
+
+	return quoteCondition(b.String()), quoteCondition(fb.String())
+}
+
+func quoteCondition(s string) string {
+	return `[` + escapeDesc(s) + `]`
+}
+
+func (p *execTrace) labels() LabelPolicy {
+	return p.fs.fs.labels
+}
+
+func (p *execTrace) shortenArgs(args []ast.Expr, maxLen int) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return p.shortenCond(args[0], maxLen)
+}
+
+func (p *execTrace) shortenCond(cond ast.Expr, maxLen int) string {
+	s := p._shortenCond(cond, maxLen)
+	if s != "" {
+		return s
+	}
+	return p.fs.excerpt(cond.Pos(), cond.End(), maxLen)
+}
+
+func (p *execTrace) _shortenCond(cond ast.Expr, maxLen int) string {
+	switch op := cond.(type) {
+	case *ast.SelectorExpr:
+		if op.Sel == nil {
+			return p._shortenCond(op.X, maxLen)
+		}
+		s := op.Sel.Name
+		if len(s) >= maxLen {
+			if op.X != nil {
+				return `(...).` + s
+			}
+			return s
+		}
+		return p._shortenCond(op.X, maxLen-len(s)-1) + `.` + s
+	case *ast.Ident:
+		return op.Name
+	case *ast.CallExpr:
+		return p._shortenCond(op.Fun, maxLen-2) + `()`
+	case *ast.BasicLit:
+		return op.Value
+	case *ast.FuncLit:
+		return "func(){}"
+	case *ast.CompositeLit:
+		return p._shortenCond(op.Type, maxLen-2) + `{}`
+	case *ast.ParenExpr:
+		return `(` + p._shortenCond(op.X, maxLen-2) + `)`
+	case *ast.IndexExpr:
+		return p._shortenCond(op.X, maxLen-2) + `[]`
+	case *ast.SliceExpr:
+		return p._shortenCond(op.X, maxLen-2) + `[]`
+	case *ast.TypeAssertExpr:
+		return p._shortenCond(op.X, maxLen)
+	case *ast.StarExpr:
+		return p._shortenCond(op.X, maxLen)
+	case *ast.UnaryExpr:
+		switch op.Op {
+		case token.AND:
+			return p._shortenCond(op.X, maxLen)
+		case token.XOR:
+			return `^` + p._shortenCond(op.X, maxLen-1)
+		case token.NOT:
+			return `!` + p._shortenCond(op.X, maxLen-1)
+		default:
+			tok := op.Op.String()
+			return tok + p._shortenCond(op.X, maxLen-len(tok))
+		}
+	case *ast.BinaryExpr:
+		tok := op.Op.String()
+		s := tok + p._shortenCond(op.Y, maxLen-1-len(tok))
+		if len(s) >= maxLen {
+			return `...` + s
+		}
+		return p._shortenCond(op.X, maxLen-len(s)) + s
+	default:
+		return "(...)"
+	}
+}
+
+func (p *execTrace) formatUpdateName(su *stateUpdate) string {
+	if len(su.args) == 0 && !su.isCall {
+		return su.name
+	}
+	return su.name + `(` + p.shortenArgs(su.args, p.labels().MaxArgLen) + `)`
+}
+
+func (p *execTrace) buildOperation(su *stateUpdate) (op, adapter string) {
+	if !su.hasName() {
+		return "", ""
+	}
+
+	if su.isContext {
+		s := su.name
+		for su = su.parent; su.hasName(); su = su.parent {
+			if len(su.args) == 0 {
+				s = `.` + su.name
+			} else {
+				s = `.` + p.formatUpdateName(su)
+			}
+		}
+		return s, ""
+	}
+
+	op = `DelayedStart`
+	switch {
+	case su.name == op:
+		op = ""
+	case su.parent != nil && su.parent.name == op && len(su.args) == 0:
+		op = su.name
+		su = su.parent
+	default:
+		return "", ""
+	}
+
+	if prep, adapt := p._extractAdapterCall(su); prep != nil {
+		prepName := ""
+		prepName, adapter = p.getAdapterCallNames(prep, adapt)
+		p.md.addAdapter(adapter)
+
+		return prepName + `.` + op, adapter
+	}
+
+	return "", ""
+}
+
+func (p *execTrace) buildCallChain(su *stateUpdate) string {
+	if su == nil {
+		return ""
+	}
+	s := p.formatUpdateName(su)
+	for su = su.parent; su.hasName(); su = su.parent {
+		s = p.formatUpdateName(su) + `.` + s
+	}
+	return s
+}
+
+func (p *execTrace) getAdapterCallNames(start, prep *stateUpdate) (prepName, adapter string) {
+	adapter = p.buildCallChain(prep.parent)
+
+	a := prep.parent
+	prep.parent = nil
+	prepName = p.buildCallChain(start.parent)
+	prep.parent = a
+
+	return prepName, adapter
+}
+
+func (p *execTrace) addAdapterCall(start, prep *stateUpdate) {
+	prepName, adapter := p.getAdapterCallNames(start, prep)
+	p.md.addAdapterCall(start.name, prepName, adapter)
+}
+
+func (p *execTrace) addChild(spec OpSpec, op string, args []ast.Expr) {
+	// CreateFunc is the first arg of NewChild, InitChild, InitChildWithPostInit and the last one of NewChildExt
+	createFn := spec.ArgOf(args)
+	if createFn == nil {
+		return
+	}
+
+	ch := MethodChild{Operation: op}
+	ch.Condition, ch.FullCondition = p.buildCondition()
+
+	switch fn := createFn.(type) {
+	case *ast.FuncLit:
+		ch.Child, ch.Constructor = p.getCreatedType(fn.Body)
+	default:
+		// a named CreateFunc, it can't be resolved to a type here
+		ch.Child = p.getInlineFuncExpr(createFn, 0)
+		if ch.Child == "" {
+			return
+		}
+		ch.Child = "DYNAMIC " + ch.Child
+	}
+
+	p.md.Children = append(p.md.Children, ch)
+}
+
+// getReplacementType returns a type of SM given to Replace(CreateFunc) or ReplaceWith(StateMachine)
+func (p *execTrace) getReplacementType(spec OpSpec, arg ast.Expr) string {
+	typeName, constructor := "", ""
+	switch fn := arg.(type) {
+	case *ast.FuncLit:
+		if !spec.Machine {
+			typeName, constructor = p.getCreatedType(fn.Body)
+		}
+	default:
+		if spec.Machine {
+			typeName, constructor = getCreatedTypeOfExpr(arg)
+		}
+	}
+	switch {
+	case typeName != "":
+		return typeName
+	case constructor != "":
+		return constructor + `()`
+	default:
+		return p.shortenArgs([]ast.Expr{arg}, p.labels().MaxArgLen)
+	}
+}
+
+// addPanic adds a terminal transition for panic(...) called by the step
+func (p *execTrace) addPanic(args []ast.Expr) {
+	mt := MethodTransition{Transition: TerminalPanic, Comment: p.nearestComment()}
+	mt.Operation = `panic(` + p.shortenArgs(args, p.labels().MaxArgLen) + `)`
+	mt.Condition, mt.FullCondition = p.buildCondition()
+	p.md.addTransition(mt)
+}
+
+// getCreatedType looks through return statements of a CreateFunc to find out a type of the created SM.
+// Returns either a type name or a name of constructor func to be resolved later.
+func (p *execTrace) getCreatedType(body *ast.BlockStmt) (typeName, constructor string) {
+	if body == nil {
+		return "", ""
+	}
+
+	locals := map[string]ast.Expr{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch op := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			if len(op.Lhs) == len(op.Rhs) {
+				for i, lhs := range op.Lhs {
+					if id, ok := lhs.(*ast.Ident); ok {
+						locals[id.Name] = op.Rhs[i]
+					}
+				}
+			}
+		case *ast.ReturnStmt:
+			if len(op.Results) != 1 || typeName != "" || constructor != "" {
+				break
+			}
+			res := op.Results[0]
+			if id, ok := res.(*ast.Ident); ok && locals[id.Name] != nil {
+				res = locals[id.Name]
+			}
+			typeName, constructor = getCreatedTypeOfExpr(res)
+		}
+		return true
+	})
+	return
+}
+
+func getCreatedTypeOfExpr(expr ast.Expr) (typeName, constructor string) {
+	if op, ok := expr.(*ast.UnaryExpr); ok && op.Op == token.AND {
+		expr = op.X
+	}
+
+	switch op := expr.(type) {
+	case *ast.CompositeLit:
+		x, sel := getSelectorOfExpr(op.Type)
+		if x != "" {
+			return x + `.` + sel, ""
+		}
+		return sel, ""
+	case *ast.CallExpr:
+		// a constructor, e.g. NewFoo() or pkg.NewFoo(), is resolved by name after all files are parsed
+		switch x, sel := getSelectorOfExpr(unstarExpr(op.Fun)); {
+		case sel == "new" && x == "" && len(op.Args) == 1:
+			return getCreatedTypeOfExpr(&ast.CompositeLit{Type: op.Args[0]})
+		default:
+			return "", sel
+		}
+	}
+	return "", ""
+}
+
+func (p *execTrace) addExchange(spec OpSpec, op string, args []ast.Expr) {
+	ex := MethodExchange{Operation: op}
+	arg := spec.ArgOf(args)
+
+	switch spec.Exchange {
+	case "share":
+		ex.Kind = ExchangeShare
+	case "publish":
+		ex.Kind = ExchangePublish
+	case "subscribe":
+		ex.Kind = ExchangeSubscribe
+	case "use":
+		if arg != nil {
+			p.addSharedAccess(op, p.exprToValue(arg))
+		}
+		return
+	case "unpublish":
+		ex.Kind = ExchangeUnpublish
+	default:
+		return
+	}
+
+	switch {
+	case ex.Kind == ExchangeShare && p.assignTo != "":
+		// consumers refer to the SharedDataLink returned by Share
+		ex.Key = sharedLinkName(p.assignTo)
+	case arg != nil:
+		ex.Key = p.fs.excerpt(arg.Pos(), arg.End(), p.labels().MaxKeyLen)
+	}
+	p.md.addExchange(ex)
+}
+
+// sharedLinkName reduces a var or a field holding SharedDataLink to its name, e.g. s.link to link,
+// so an access to the link matches its Share
+func sharedLinkName(link string) string {
+	if n := strings.LastIndexByte(link, '.'); n >= 0 {
+		return link[n+1:]
+	}
+	return link
+}
+
+// addSharedAccess handles an accessor made by SharedDataLink.PrepareAccess(...) and similar
+func (p *execTrace) addSharedAccess(op string, accessor *stateUpdate) {
+	ex := MethodExchange{Kind: ExchangeUse, Operation: op}
+	if accessor != nil && accessor.isCall && p.op(accessor.name).Exchange == "access" && accessor.parent.hasName() {
+		ex.Key = sharedLinkName(p.buildCallChain(accessor.parent))
+	}
+	p.md.addExchange(ex)
+}
+
+func (p *execTrace) addSyncOp(op string, arg ast.Expr) string {
+	so := MethodSyncOp{Operation: op}
+	if arg != nil {
+		so.Link = p.getSyncLinkName(arg)
+	}
+	p.md.addSyncOp(so)
+	return so.Link
+}
+
+func (p *execTrace) getSyncLinkName(expr ast.Expr) string {
+	// s.limiter.NewDelta(1) or s.barrier.SyncLink() are reduced to the sync object
+	if su := p.exprToValue(expr); su != nil && su.isCall && su.parent.hasName() {
+		return p.buildCallChain(su.parent)
+	}
+	return p.fs.excerpt(expr.Pos(), expr.End(), p.labels().MaxKeyLen)
+}
+
+// addBargeIn handles a method of the barge-in builder, e.g. WithJump, or NewBargeInWithParam
+func (p *execTrace) addBargeIn(spec OpSpec, op string, args []ast.Expr) {
+	bi := MethodBargeIn{Name: p.assignTo, Operation: op}
+	arg := spec.ArgOf(args)
+
+	switch {
+	case spec.Terminal == "stop":
+		bi.Transition = TerminalStop
+	case spec.Terminal == "error":
+		bi.Transition = TerminalError
+	case spec.Then:
+		// WithJump(step) or WithWakeUp()
+		if arg != nil {
+			bi.Transition = p.getInlineFuncExpr(arg, Execution)
+		}
+	case arg == nil:
+		// NewBargeIn() is followed by a method of the builder
+		return
+	default:
+		// NewBargeInWithParam(func(param interface{}) BargeInCallbackFunc { return func(ctx BargeInContext) StateUpdate {...} })
+		fn, ok := arg.(*ast.FuncLit)
+		if !ok {
+			bi.Transition = p.getInlineFuncExpr(arg, BargeIn)
+			break
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch op := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.ReturnStmt:
+				if len(op.Results) == 1 && bi.Transition == "" {
+					bi.Transition = p.getInlineFuncExpr(op.Results[0], BargeIn)
+				}
+			}
+			return true
+		})
+	}
+
+	p.md.BargeIns = append(p.md.BargeIns, bi)
+}
+
+// getStepFlagNames converts StepFlags expression, e.g. smachine.StepPriority|smachine.StepWeak, into "priority,weak"
+func getStepFlagNames(expr ast.Expr) string {
+	var names []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch op := n.(type) {
+		case *ast.SelectorExpr:
+			names = append(names, op.Sel.Name)
+			return false
+		case *ast.Ident:
+			names = append(names, op.Name)
+		case *ast.CallExpr:
+			return false
+		}
+		return true
+	})
+
+	for i, n := range names {
+		if strings.HasPrefix(n, "Step") && len(n) > 4 {
+			n = n[4:]
+		}
+		names[i] = strings.ToLower(n)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,323 @@
+package smuml
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testChildSM = `
+type SMChild struct{ smachine.StateMachineDeclTemplate }
+
+func NewSMChild() *SMChild {
+	return &SMChild{}
+}
+
+func (s *SMChild) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+
+func TestChildOfCompositeLit(t *testing.T) {
+	const src = `
+type SMParent struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMParent) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.NewChild(func(ctx smachine.ConstructionContext) smachine.StateMachine {
+		return &SMChild{}
+	})
+	return ctx.Stop()
+}
+` + testChildSM
+
+	children := resolveSM(t, src, "SMParent").Steps["Init"].Children
+	if len(children) != 1 || children[0].Child != "SMChild" || children[0].Operation != "NewChild" {
+		t.Fatalf("unexpected children: %+v", children)
+	}
+
+	edges := renderEdges(t, src)
+	requireEdges(t, edges,
+		"Init --> <<fork>>",
+		"<<fork>> --[#blue]> Init : NewChild",
+	)
+}
+
+func TestChildOfConstructor(t *testing.T) {
+	for _, ctor := range []string{"NewSMChild()", "s.factory.NewSMChild()", "childpkg.NewSMChild()"} {
+		t.Run(ctor, func(t *testing.T) {
+			src := `
+type SMParent struct {
+	smachine.StateMachineDeclTemplate
+	factory struct{ NewSMChild func() *SMChild }
+}
+
+func (s *SMParent) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.InitChild(func(ctx smachine.ConstructionContext) smachine.StateMachine {
+		return ` + ctor + `
+	})
+	return ctx.Stop()
+}
+` + testChildSM
+
+			children := resolveSM(t, src, "SMParent").Steps["Init"].Children
+			if len(children) != 1 || children[0].Child != "SMChild" || children[0].ChildTo == nil {
+				t.Fatalf("child is not resolved: %+v", children)
+			}
+		})
+	}
+}
+
+func TestSharedLinkExchanges(t *testing.T) {
+	const src = `
+type SMOwner struct {
+	smachine.StateMachineDeclTemplate
+	link smachine.SharedDataLink
+}
+
+func (s *SMOwner) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	s.link = ctx.Share(s, 0)
+	ctx.Publish("key", s.link)
+	ctx.Unpublish("key")
+	return ctx.Stop()
+}
+
+type SMUser struct {
+	smachine.StateMachineDeclTemplate
+	link smachine.SharedDataLink
+}
+
+func (s *SMUser) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	ctx.UseShared(s.link.PrepareAccess(nil))
+	s.link.PrepareAccess(nil).TryUse(ctx)
+	return ctx.Stop()
+}
+`
+	for _, tc := range []struct {
+		rType string
+		want  []MethodExchange
+	}{
+		{"SMOwner", []MethodExchange{
+			{Kind: ExchangeShare, Operation: "Share", Key: "link"},
+			{Kind: ExchangePublish, Operation: "Publish", Key: `"key"`},
+			{Kind: ExchangeUnpublish, Operation: "Unpublish", Key: `"key"`},
+		}},
+		{"SMUser", []MethodExchange{
+			{Kind: ExchangeUse, Operation: "UseShared", Key: "link"},
+			{Kind: ExchangeUse, Operation: "TryUse", Key: "link"},
+		}},
+	} {
+		got := resolveSM(t, src, tc.rType).Steps["Init"].Exchanges
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.rType, got, tc.want)
+		}
+	}
+
+	edges := renderEdges(t, src)
+	requireEdges(t, edges,
+		`queue "'key'" as K001`,
+		"T00 --> K001 : Publish\\nInit",
+		"T00 -[#red,dashed]-x K001 : Unpublish\\nInit",
+	)
+}
+
+func TestSyncWait(t *testing.T) {
+	const src = `
+type SMSync struct {
+	smachine.StateMachineDeclTemplate
+	sync, other smachine.SyncLink
+	c           bool
+}
+
+func (s *SMSync) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if ctx.Acquire(s.sync).IsNotPassed() {
+		return ctx.Sleep().ThenRepeat()
+	}
+	return ctx.Jump(s.stepUngated)
+}
+
+func (s *SMSync) stepUngated(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	ctx.Acquire(s.other)
+	if s.c {
+		return ctx.Sleep().ThenRepeat()
+	}
+	return ctx.Jump(s.stepChained)
+}
+
+func (s *SMSync) stepChained(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Acquire(s.other).ThenRepeat()
+}
+`
+	sm := resolveSM(t, src, "SMSync")
+	for _, tc := range []struct {
+		step, link, cond string
+	}{
+		{"Init", "s.sync", "[ctx.Acquire().IsNotPassed() && wait for s.sync]"},
+		{"stepUngated", "", "[s.c]"},
+		{"stepChained", "s.other", "[wait for s.other]"},
+	} {
+		mt := sm.Steps[tc.step].Transitions[0]
+		if mt.SyncLink != tc.link || mt.Condition != tc.cond {
+			t.Errorf("%s: got %q %q, want %q %q", tc.step, mt.SyncLink, mt.Condition, tc.link, tc.cond)
+		}
+	}
+
+	requireEdges(t, renderEdges(t, src),
+		`node "s.sync" as L002`,
+		"T00 --> L002 : Acquire\\nInit",
+	)
+}
+
+func TestTerminals(t *testing.T) {
+	const src = `
+type SMTerm struct {
+	smachine.StateMachineDeclTemplate
+	err error
+	n   int
+}
+
+func (s *SMTerm) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	switch s.n {
+	case 1:
+		return ctx.Error(s.err)
+	case 2:
+		panic("boom")
+	case 3:
+		return ctx.Replace(func(ctx smachine.ConstructionContext) smachine.StateMachine {
+			return &SMTerm{}
+		})
+	}
+	return ctx.Stop()
+}
+`
+	requireEdges(t, renderEdges(t, src),
+		"Init --[#red]> error : [s.n==1]\\nError(s.err)",
+		"Init --[#red,bold]> panic : [s.n==2]\\npanic(\"boom\")",
+		"Init --> [*] : [s.n!=1 && s.n!=2 && s.n!=3]",
+		// CreateFunc of Replace is a construction step that ends with the replacement
+		"Init --> Init.1 : [s.n==3]\\nReplace",
+		"Init.1 --[#blue,dashed]> replace : Replace: SMTerm",
+	)
+}
+
+func TestMaxArgLen(t *testing.T) {
+	const src = `
+type SMArgs struct {
+	smachine.StateMachineDeclTemplate
+	errs struct{ lastError error }
+}
+
+func (s *SMArgs) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.Error(s.errs.lastError)
+}
+`
+	for _, tc := range []struct {
+		maxArgLen int
+		op        string
+	}{
+		{maxArgLen, "Error((...).errs.lastError)"},
+		{40, "Error(s.errs.lastError)"},
+	} {
+		labels := DefaultLabelPolicy()
+		labels.MaxArgLen = tc.maxArgLen
+		sm := resolveSM(t, src, "SMArgs", func(fs *FileSet) { fs.SetLabelPolicy(labels) })
+		if got := sm.Steps["Init"].Transitions[0].Operation; got != tc.op {
+			t.Errorf("MaxArgLen %d: got %s, want %s", tc.maxArgLen, got, tc.op)
+		}
+	}
+}
+
+func TestBargeInTerminals(t *testing.T) {
+	const src = `
+type SMFoo struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMFoo) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	stop := ctx.NewBargeIn().WithStop()
+	fail := ctx.NewBargeIn().WithError(nil)
+	_, _ = stop, fail
+	return ctx.Jump(s.stepWait)
+}
+
+func (s *SMFoo) stepWait(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Sleep().ThenRepeat()
+}
+`
+	sm := resolveSM(t, src, "SMFoo")
+	transitions := map[string]string{}
+	for _, bi := range sm.Steps["Init"].BargeIns {
+		transitions[bi.Operation] = bi.Transition
+	}
+	if transitions["WithStop"] != TerminalStop || transitions["WithError"] != TerminalError {
+		t.Errorf("unexpected barge-ins: %v", transitions)
+	}
+
+	requireEdges(t, renderEdges(t, src),
+		`any state --[#orange,bold]> [*] : stop\nWithStop`,
+		`any state --[#orange,bold]> error : fail\nWithError`,
+	)
+}
+
+func TestBargeIns(t *testing.T) {
+	const src = `
+type SMBarge struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMBarge) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	wake := ctx.NewBargeIn().WithJump(s.stepWake)
+	_ = wake
+	ctx.NewBargeInWithParam(func(param interface{}) smachine.BargeInCallbackFunc {
+		return func(ctx smachine.BargeInContext) smachine.StateUpdate {
+			return ctx.JumpExt(smachine.SlotStep{Transition: s.stepWake})
+		}
+	})
+	return ctx.Sleep().ThenRepeat()
+}
+
+func (s *SMBarge) stepWake(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	bargeIns := resolveSM(t, src, "SMBarge").Steps["Init"].BargeIns
+	if len(bargeIns) != 2 || bargeIns[0].Name != "wake" || bargeIns[0].Transition != "s.stepWake" ||
+		bargeIns[1].Operation != "NewBargeInWithParam" || bargeIns[1].Transition != "Init.1" {
+		t.Fatalf("unexpected barge-ins: %+v", bargeIns)
+	}
+
+	requireEdges(t, renderEdges(t, src),
+		"Init --[dashed]> Init : Sleep",
+		"any state --[#orange,bold]> stepWake : wake",
+		// the callback is traced as a sub-step
+		"any state --[#orange,bold]> Init.1 : BargeIn",
+		"Init.1 --> stepWake",
+	)
+}
+
+func TestSlotStepFields(t *testing.T) {
+	const src = `
+type SMSlot struct{ smachine.StateMachineDeclTemplate }
+
+func (s *SMSlot) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	return ctx.JumpExt(smachine.SlotStep{s.stepWork, s.migrate, s.onError, smachine.StepPriority})
+}
+
+func (s *SMSlot) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	step := &smachine.SlotStep{Transition: s.Init, Flags: smachine.StepWeak}
+	return ctx.JumpExt(*step)
+}
+
+func (s *SMSlot) migrate(ctx smachine.MigrationContext) smachine.StateUpdate {
+	return ctx.Stay()
+}
+
+func (s *SMSlot) onError(ctx smachine.FailureContext) {
+}
+`
+	tr := resolveSM(t, src, "SMSlot").Steps["Init"].Transitions[0]
+	if tr.Transition != "s.stepWork" || tr.Migration != "s.migrate" || tr.SlotHandler != "s.onError" || tr.SlotFlags != "priority" {
+		t.Fatalf("unexpected transition: %+v", tr)
+	}
+
+	requireEdges(t, renderEdges(t, src),
+		`Init --> stepWork : Migrate: s.migrate\nHandler: s.onError\nFlags: priority`,
+		"stepWork --[dotted]> migrate",
+		// a SlotStep passed by a pointer
+		`stepWork --> Init : Flags: weak`,
+	)
+}
@@ -0,0 +1,66 @@
+package smuml
+
+import "testing"
+
+func TestBranchComment(t *testing.T) {
+	const src = `
+type SMComment struct {
+	smachine.StateMachineDeclTemplate
+	a, b bool
+}
+
+func (s *SMComment) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	// ready to work
+	if s.a {
+		if s.b {
+			return ctx.Jump(s.stepWork)
+		}
+		return ctx.Stop()
+	}
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMComment) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+	edges := renderEdges(t, src)
+	requireEdges(t, edges,
+		"Init --> [*] : ready to work",
+		// a nested branch has no comment of its own
+		"Init --> stepWork : [s.a && s.b]",
+		// the comment belongs to the if branch only
+		"Init --> stepWork : [!s.a]",
+	)
+	rejectEdges(t, edges, "Init --> stepWork : ready to work")
+}
+
+func TestReturnedCallUsages(t *testing.T) {
+	const src = `
+type SMUsages struct {
+	smachine.StateMachineDeclTemplate
+	ok bool
+}
+
+func (s *SMUsages) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if s.ok {
+		su := ctx.Jump(s.stepWork)
+		return su
+	}
+	return ctx.Jump(s.stepWork)
+}
+
+func (s *SMUsages) stepWork(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	link := ctx.Share(s, 0)
+	_ = link
+	return ctx.Publish(s, link)
+}
+`
+	sm := resolveSM(t, src, "SMUsages")
+	if usages := sm.Steps["Init"].Usages; usages["Jump"] != 2 {
+		t.Errorf("unexpected usages of Init: %v", usages)
+	}
+	if usages := sm.Steps["stepWork"].Usages; usages["Share"] != 1 || usages["Publish"] != 1 {
+		t.Errorf("unexpected usages of stepWork: %v", usages)
+	}
+}
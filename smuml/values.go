@@ -0,0 +1,189 @@
+package smuml
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// stepValue is a value assigned to a local variable that holds a step, a SlotStep or a migration func
+type stepValue struct {
+	expr  ast.Expr
+	trace *execTrace
+}
+
+// resolvedValue is a possible value of a variable at a point of use, with conditions when it is possible
+type resolvedValue struct {
+	expr  ast.Expr
+	conds []ast.Expr
+}
+
+func (p *execTrace) root() *execTrace {
+	et := p
+	for et.parent != nil {
+		et = et.parent
+	}
+	return et
+}
+
+func (p *execTrace) isAncestorOf(et *execTrace) bool {
+	for ; et != nil; et = et.parent {
+		if et == p {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrackedValue returns true for expressions that can be a step: a method value of the receiver, a SlotStep literal or a func literal
+func (p *execTrace) isTrackedValue(expr ast.Expr) bool {
+	switch op := expr.(type) {
+	case *ast.UnaryExpr:
+		return op.Op == token.AND && p.isTrackedValue(op.X)
+	case *ast.FuncLit:
+		return true
+	case *ast.CompositeLit:
+		_, sel := getSelectorOfExpr(op.Type)
+		return sel == "SlotStep"
+	case *ast.SelectorExpr:
+		x, _ := getSelectorOfExpr(op)
+		return x != "" && x == p.md.RName
+	}
+	return false
+}
+
+// trackValues records assignments to local variables, e.g. `next := s.stepA` or `next = s.stepB`
+func (p *execTrace) trackValues(lhs []ast.Expr, rhs []ast.Expr) {
+	if len(lhs) != len(rhs) {
+		return
+	}
+	root := p.root()
+	for i, l := range lhs {
+		if lit, ok := rhs[i].(*ast.FuncLit); ok {
+			// an inline step is named after the variable or the field it is assigned to
+			if _, name := getSelectorOfExpr(l); name != "" && name != "_" {
+				p.md.nameInlineFunc(lit, name)
+			}
+		}
+		id, ok := l.(*ast.Ident)
+		if !ok || id.Name == "_" {
+			continue
+		}
+		if !p.isTrackedValue(rhs[i]) && root.values[id.Name] == nil {
+			continue
+		}
+		if root.values == nil {
+			root.values = map[string][]stepValue{}
+		}
+
+		// values assigned in this trace or in completed branches inside of it are overwritten
+		values := root.values[id.Name][:0:0]
+		for _, v := range root.values[id.Name] {
+			if !p.isAncestorOf(v.trace) {
+				values = append(values, v)
+			}
+		}
+		root.values[id.Name] = append(values, stepValue{expr: rhs[i], trace: p})
+	}
+}
+
+func (p *execTrace) untrackValue(name string) {
+	if root := p.root(); root.values != nil {
+		delete(root.values, name)
+	}
+}
+
+// resolveValues returns possible values of a local variable at this point of trace
+func (p *execTrace) resolveValues(expr ast.Expr) []resolvedValue {
+	id, ok := unstarExpr(expr).(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	values := p.root().values[id.Name]
+	if len(values) == 0 {
+		return nil
+	}
+
+	var result []resolvedValue
+	for i, v := range values {
+		if !p.isVisible(v.trace) {
+			continue
+		}
+
+		rv := resolvedValue{expr: v.expr, conds: v.trace.pathConds()}
+		for _, w := range values[i+1:] {
+			switch {
+			case !p.isVisible(w.trace):
+			case w.trace.isAncestorOf(p):
+				// overwritten on the path to this point
+				rv.expr = nil
+			default:
+				// overwritten inside of a completed branch, so this value is possible when the branch wasn't taken
+				if cond := p.branchCondOf(w.trace); cond != nil {
+					rv.conds = append(rv.conds, negateCond(cond))
+				} else {
+					rv.expr = nil
+				}
+			}
+			if rv.expr == nil {
+				break
+			}
+		}
+		if rv.expr != nil && !hasContradiction(append(p.pathConds(), rv.conds...)) {
+			result = append(result, rv)
+		}
+	}
+	return result
+}
+
+// isVisible returns false when the trace is in an alternative branch, e.g. in `then` while this one is in `else`,
+// or when the trace is in a completed branch that has returned.
+func (p *execTrace) isVisible(et *execTrace) bool {
+	for ; et != nil; et = et.parent {
+		if et.terminated && !et.isAncestorOf(p) {
+			return false
+		}
+		if et.parent == nil || et.parent.isAncestorOf(p) {
+			break
+		}
+	}
+	if et == nil || et.isAncestorOf(p) || et.alt == nil {
+		return true
+	}
+	for my := p; my != nil; my = my.parent {
+		if my.parent == et.parent {
+			return my.alt != et.alt
+		}
+	}
+	return true
+}
+
+// branchCondOf returns a condition of branches between the given trace and the nearest common trace with this one
+func (p *execTrace) branchCondOf(et *execTrace) ast.Expr {
+	var cond ast.Expr
+	for ; et != nil && !et.isAncestorOf(p); et = et.parent {
+		switch {
+		case et.cond == nil:
+		case cond == nil:
+			cond = et.cond
+		default:
+			cond = conjoinCond(et.cond, cond)
+		}
+	}
+	return cond
+}
+
+func (p *execTrace) pathConds() []ast.Expr {
+	var conds []ast.Expr
+	for et := p; et != nil; et = et.parent {
+		if et.cond != nil {
+			conds = append([]ast.Expr{et.cond}, conds...)
+		}
+	}
+	return conds
+}
+
+// hasContradiction returns true when conditions can't be met together, e.g. contain both a term and its negation
+func hasContradiction(conds []ast.Expr) bool {
+	_, ok := simplifyConds(conds)
+	return !ok
+}
@@ -0,0 +1,105 @@
+package smuml
+
+import "testing"
+
+const testValuesSteps = `
+func (s *SMValues) stepA(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+
+func (s *SMValues) stepB(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	return ctx.Stop()
+}
+`
+
+func TestStepVariable(t *testing.T) {
+	const src = `
+type SMValues struct {
+	smachine.StateMachineDeclTemplate
+	c bool
+}
+
+func (s *SMValues) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	next := s.stepA
+	if s.c {
+		next = s.stepB
+	}
+	return ctx.Jump(next)
+}
+` + testValuesSteps
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepA : [!s.c]",
+		"Init --> stepB : [s.c]",
+	)
+}
+
+func TestSlotStepVariable(t *testing.T) {
+	const src = `
+type SMValues struct {
+	smachine.StateMachineDeclTemplate
+	c bool
+}
+
+func (s *SMValues) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	step := smachine.SlotStep{Transition: s.stepB}
+	if s.c {
+		step = smachine.SlotStep{Transition: s.Init, Migration: s.migrate}
+	}
+	return ctx.JumpExt(step)
+}
+
+func (s *SMValues) stepB(ctx smachine.ExecutionContext) smachine.StateUpdate {
+	m := s.migrate
+	ctx.SetDefaultMigration(m)
+	return ctx.Jump(s.Init)
+}
+
+func (s *SMValues) migrate(ctx smachine.MigrationContext) smachine.StateUpdate {
+	return ctx.Stay()
+}
+`
+	requireEdges(t, renderEdges(t, src),
+		"Init --> stepB : [!s.c]",
+		`Init --> Init : [s.c]\nMigrate: s.migrate`,
+		"stepB --> Init : Migrate: s.migrate",
+	)
+}
+
+func TestValueConditionsAreSimplified(t *testing.T) {
+	const src = `
+type SMValues struct {
+	smachine.StateMachineDeclTemplate
+	a, b bool
+}
+
+func (s *SMValues) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	next := s.stepA
+	if !s.a {
+		if s.b {
+			next = s.stepB
+		}
+	}
+	if !s.a {
+		return ctx.Jump(next)
+	}
+	if s.a || s.b {
+		next = s.stepB
+	}
+	return ctx.Jump(next)
+}
+` + testValuesSteps
+	sm := resolveSM(t, src, "SMValues")
+	var conds []string
+	for _, tr := range sm.Steps["Init"].Transitions {
+		conds = append(conds, tr.Transition+" "+tr.Condition)
+	}
+	want := []string{"s.stepA [!s.a && !s.b]", "s.stepB [!s.a && s.b]", "s.stepB [s.a]"}
+	if len(conds) != len(want) {
+		t.Fatalf("got %q, want %q", conds, want)
+	}
+	for i := range want {
+		if conds[i] != want[i] {
+			t.Errorf("got %q, want %q", conds[i], want[i])
+		}
+	}
+}
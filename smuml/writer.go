@@ -0,0 +1,645 @@
+package smuml
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is an output format of diagrams
+type Format string
+
+// FormatPlantUML is the PlantUML state diagram format
+const FormatPlantUML Format = "plantuml"
+
+// umlWriter writes PlantUML diagrams of SMs, the first write error stops further output
+type umlWriter struct {
+	output    string
+	out       *bufio.Writer
+	err       error
+	unknownId int
+
+	page         map[*SMDecl]bool
+	umlExtension string
+	usageFilter  map[string]bool
+	labels       LabelPolicy
+	condTable    []string
+	terminals    map[string]string
+}
+
+func (p *umlWriter) h(_ int, err error) {
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *umlWriter) line(s ...string) {
+	p.put(s...)
+	if p.err == nil {
+		p.h(0, p.out.WriteByte('\n'))
+	}
+}
+
+func (p *umlWriter) put(s ...string) {
+	for _, si := range s {
+		if p.err != nil {
+			return
+		}
+		p.h(p.out.WriteString(si))
+	}
+}
+
+// writeUML writes a diagram page of resolved SMs, children from other pages are shown as links to their files
+func (p *umlWriter) writeUML(decls []*SMDecl) {
+	p.line(`@startuml`)
+	if len(decls) == 1 && len(decls[0].TypeParams) > 0 {
+		p.line(`title `, decls[0].Title())
+	}
+	p.page = make(map[*SMDecl]bool, len(decls))
+	for _, d := range decls {
+		p.page[d] = true
+	}
+	for _, d := range decls {
+		// if i > 0 {
+		// 	p.L(`newpage`)
+		// }
+		p.writeDecl(d)
+	}
+
+	p.writeCondTable()
+	p.line(`@enduml`)
+
+	p.writeExchanges(decls)
+}
+
+func (p *umlWriter) writeDecl(d *SMDecl) {
+	stepNames := make([]string, 0, len(d.Steps))
+	for k := range d.Steps {
+		stepNames = append(stepNames, k)
+	}
+	sort.Strings(stepNames)
+
+	startType := Initialization
+	if d.HasDeclInit {
+		startType = DeclarationInit
+	}
+
+	p.writeGroups(d, stepNames)
+
+	for _, k := range stepNames {
+		step := d.Steps[k]
+		stepAlias := p.stepAlias(d, step.Name, step)
+
+		if step.Group == "" {
+			p.writeStepDecl(stepAlias, step)
+		}
+		switch {
+		case step.IsSubroutine:
+		case d.DeclType != "" && step.RType == d.DeclType:
+			p.line(stepAlias, " : ", d.DeclType)
+		default:
+			p.line(stepAlias, " : ", d.Title())
+		}
+		if name := step.DisplayName(); name != step.Name {
+			p.line(stepAlias, " : ", escapeDesc(step.Name))
+		}
+		if step.Doc != "" && step.DisplayName() != step.DocName() {
+			p.line(stepAlias, " : <i>", escapeDesc(firstLine(step.Doc)), "</i>")
+		}
+		switch {
+		case step.Shadowed:
+			p.line(stepAlias, " : overridden by ", d.Title())
+		case step.InheritedFrom != "":
+			p.line(stepAlias, " : inherited from ", step.InheritedFrom)
+		case step.Overrides != "":
+			p.line(stepAlias, " : overrides ", step.Overrides)
+		}
+		if step.Duplicate {
+			p.line(stepAlias, " : ", "DUPLICATE")
+		}
+
+		for _, so := range step.SyncOps {
+			p.line(stepAlias, " : ", so.Operation, "(", escapeDesc(so.Link), ")")
+		}
+		for _, ex := range step.Exchanges {
+			p.line(stepAlias, " : ", ex.Operation, "(", escapeDesc(ex.Key), ")")
+		}
+
+		p.writeUsages(stepAlias, step)
+
+		if step.MType == startType && !step.Shadowed {
+			p.line("[*] --> ", stepAlias)
+		}
+
+		connIdx := 0
+
+		if d.Hooks != nil && d.Hooks.ShadowMigrate != "" && step.MType == Execution {
+			// a shadow migration is applied on every migration regardless of migrations of the step
+			p.line(stepAlias, " : <size:10>shadow migration: ", escapeDesc(d.Hooks.ShadowMigrate), "</size>")
+		}
+		if n := len(step.Migrations); step.MType == Execution && n > 0 {
+
+			mirgateNames := make([]string, 0, n)
+			for k := range step.Migrations {
+				if k != "" {
+					mirgateNames = append(mirgateNames, k)
+				}
+			}
+			sort.Strings(mirgateNames)
+			for _, k := range mirgateNames {
+				toStep := p.stepAlias(d, k, d.findStep(k))
+				p.jumpMigrate(stepAlias, toStep)
+			}
+		}
+
+		if step.MType != Failure {
+			for _, k := range sortedSet(step.ErrorHandlers) {
+				toStep := p.stepAlias(d, k, d.findStep(k))
+				p.jumpErrorHandler(stepAlias, toStep)
+			}
+		}
+
+		for _, tr := range step.Transitions {
+			connIdx++
+
+			waitOperation := tr.WaitTransition
+
+			if tr.TransitionTo == nil || !tr.TransitionTo.IsSubroutine {
+				m := ""
+				switch {
+				case IsTerminal(tr.Transition):
+					//
+				case tr.Migration != "":
+					m = `Migrate: ` + tr.Migration
+				case !tr.InheritMigration && !step.IsSubroutine:
+					m = "Migrate: <nil>"
+				}
+				if tr.SlotHandler != "" {
+					m = joinLines(m, `Handler: `+tr.SlotHandler)
+				}
+				if tr.SlotFlags != "" {
+					m = joinLines(m, `Flags: `+tr.SlotFlags)
+				}
+				switch {
+				case m == "":
+				case tr.Operation == "":
+					tr.Operation = m
+				default:
+					tr.Operation = m + `\n` + tr.Operation
+				}
+			}
+
+			cond := p.condLabel(tr.Condition, tr.FullCondition, tr.Comment)
+			note := ""
+			switch {
+			case tr.Operation == "":
+				note = cond
+			case cond == "":
+				note = tr.Operation
+			default:
+				note = cond + `\n` + tr.Operation
+			}
+			if tr.Repeatable {
+				note = joinLines(note, `<i>in loop</i>`)
+			}
+
+			switch {
+			case tr.Transition == TerminalStop:
+				if tr.Result != "" {
+					note = joinLines(note, `Result: `+tr.Result)
+				}
+				p.jumpFixed(stepAlias, `[*]`, note)
+				continue
+			case IsTerminal(tr.Transition):
+				p.jumpTerminal(stepAlias, p.terminalAlias(d, tr.Transition), tr.Transition, note)
+				continue
+			case tr.Transition == "": // self loop
+				if tr.DelayedStart == "" {
+					p.jump(stepAlias, stepAlias, note, waitOperation)
+					continue
+				}
+				fork, op := p.jumpFork(d, stepAlias, tr.DelayedStart, cond, tr.Operation)
+				p.jump(fork, stepAlias, op, waitOperation)
+
+			case tr.DelayedStart != "":
+				fork, op := p.jumpFork(d, stepAlias, tr.DelayedStart, cond, tr.Operation)
+				toStep := p.stepAlias(d, tr.Transition, tr.TransitionTo)
+				p.jump(fork, toStep, op, waitOperation)
+
+			case tr.IndirectVia != "":
+				toStep := p.stepAlias(d, tr.Transition, tr.TransitionTo)
+				p.jumpIndirect(stepAlias, toStep, joinLines(note, `via `+tr.IndirectVia))
+
+			default:
+				toStep := p.stepAlias(d, tr.Transition, tr.TransitionTo)
+				p.jump(stepAlias, toStep, note, waitOperation)
+			}
+		}
+
+		for _, ch := range step.Children {
+			p.jumpChild(d, stepAlias, ch)
+		}
+	}
+
+	p.writeBargeIns(d, stepNames)
+	p.writeDeclHooks(d)
+}
+
+// writeUsages writes calls to context made by a step, as one line per category, e.g. "log: Log x2, LogAsync"
+func (p *umlWriter) writeUsages(stepAlias string, step *MethodDecl) {
+	categories := map[string][]string{}
+	for name, n := range step.Usages {
+		category := step.ops.UsageCategory(name)
+		if p.usageFilter != nil && !p.usageFilter[category] {
+			continue
+		}
+		if n > 1 {
+			name += " x" + strconv.Itoa(n)
+		}
+		categories[category] = append(categories[category], name)
+	}
+
+	categoryNames := make([]string, 0, len(categories))
+	for k := range categories {
+		categoryNames = append(categoryNames, k)
+	}
+	sort.Strings(categoryNames)
+
+	for _, category := range categoryNames {
+		names := categories[category]
+		sort.Strings(names)
+		p.line(stepAlias, " : <size:10>", category, ": ", strings.Join(names, ", "), "</size>")
+	}
+}
+
+func (p *umlWriter) writeStepDecl(stepAlias string, step *MethodDecl) {
+	stereotype := ""
+	switch {
+	case step.IsSubroutine:
+		stereotype = " <<sdlreceive>>"
+	case step.MType == Failure:
+		stereotype = " <<error handler>>"
+	case len(step.StepFlags) > 0:
+		stereotype = " <<" + strings.Join(sortedSet(step.StepFlags), ",") + ">>"
+	}
+
+	name := step.DisplayName()
+	p.line("state ", quoteName(name), " as ", stepAlias, stereotype)
+}
+
+// writeGroups writes composite states for steps grouped by //smuml:group directive
+func (p *umlWriter) writeGroups(d *SMDecl, stepNames []string) {
+	groups := map[string][]*MethodDecl{}
+	var groupNames []string
+	for _, k := range stepNames {
+		step := d.Steps[k]
+		if step.Group == "" {
+			continue
+		}
+		if groups[step.Group] == nil {
+			groupNames = append(groupNames, step.Group)
+		}
+		groups[step.Group] = append(groups[step.Group], step)
+	}
+	sort.Strings(groupNames)
+
+	for i, g := range groupNames {
+		p.line("state ", quoteName(g), " as ", fmt.Sprintf("T%02d_G%03d", d.SeqNo, i+1), " {")
+		for _, step := range groups[g] {
+			p.writeStepDecl(p.stepAlias(d, step.Name, step), step)
+		}
+		p.line("}")
+	}
+}
+
+func (p *umlWriter) writeBargeIns(d *SMDecl, stepNames []string) {
+	anyAlias := ""
+	for _, k := range stepNames {
+		for _, bi := range d.Steps[k].BargeIns {
+			if anyAlias == "" {
+				anyAlias = fmt.Sprintf("T%02d_ANY", d.SeqNo)
+				p.line(`state "any state" as `, anyAlias, " <<bargein>>")
+				p.line(anyAlias, " : ", d.Title())
+			}
+
+			note := bi.Name
+			if note == "" {
+				note = "BargeIn"
+			}
+			if bi.Transition == "" || IsTerminal(bi.Transition) {
+				note += `\n` + bi.Operation
+			}
+
+			toStep := anyAlias
+			switch bi.Transition {
+			case "":
+			case TerminalStop:
+				toStep = `[*]`
+			case TerminalError:
+				toStep = p.terminalAlias(d, bi.Transition)
+			default:
+				toStep = p.stepAlias(d, bi.Transition, d.findStep(bi.Transition))
+			}
+			p.writeConn(anyAlias, toStep, "--[#orange,bold]>", note)
+		}
+	}
+}
+
+// writeDeclHooks writes a note on hooks of the declaration, a shadow migration is written on every step
+func (p *umlWriter) writeDeclHooks(d *SMDecl) {
+	hooks := d.Hooks
+	if hooks == nil {
+		return
+	}
+
+	var lines []string
+	if hooks.StepLogger != "" {
+		lines = append(lines, "step logger: "+escapeDesc(hooks.StepLogger))
+	}
+	if hooks.StepDeclaration != "" {
+		lines = append(lines, "step declarations: "+escapeDesc(hooks.StepDeclaration))
+	}
+	if hooks.IsConsecutive {
+		lines = append(lines, "consecutive steps: IsConsecutive")
+	}
+	if len(lines) > 0 {
+		p.line(`note "`, d.Title(), `\n`, strings.Join(lines, `\n`), `" as `, fmt.Sprintf("T%02d_HOOKS", d.SeqNo))
+	}
+}
+
+func (p *umlWriter) jumpChild(d *SMDecl, from string, ch MethodChild) {
+	fork := p.newNamelessStep(d, "", " <<fork>>")
+	p.jumpFixed(from, fork, p.condLabel(ch.Condition, ch.FullCondition, ""))
+	p.writeConn(fork, p.childAlias(d, ch), "--[#blue]>", ch.Operation)
+}
+
+func (p *umlWriter) childAlias(d *SMDecl, ch MethodChild) string {
+	if ch.ChildTo != nil && p.page[ch.ChildTo] {
+		if entry := ch.ChildTo.EntryStep(); entry != nil {
+			return p.stepAlias(ch.ChildTo, entry.Name, entry)
+		}
+	}
+
+	name := ch.Child
+	if name == "" {
+		name = ch.Constructor + `()`
+	}
+	childAlias := p.newNamelessStep(d, name, " <<child>>")
+	if ch.ChildTo != nil {
+		p.line(childAlias, " : [[", filepath.Base(ch.ChildTo.Output)+p.umlExtension, "]]")
+	}
+	return childAlias
+}
+
+func (p *umlWriter) jumpFork(d *SMDecl, from, toAdapter, cond, op string) (forkAlias, nextOp string) {
+	fork := p.newNamelessStep(d, "", " <<fork>>")
+	adapter := p.stepAlias(d, toAdapter, d.findStep(toAdapter))
+	p.jumpFixed(from, fork, cond)
+
+	i := strings.IndexByte(op, '.') // MUST be there
+
+	p.jumpFixed(fork, adapter, op[:i])
+	return fork, op[i+1:]
+}
+
+func (p *umlWriter) jumpMigrate(from, to string) {
+	p.writeConn(from, to, "--[dotted]>", "")
+}
+
+func (p *umlWriter) jumpErrorHandler(from, to string) {
+	p.writeConn(from, to, "--[#red,dotted]>", "")
+}
+
+func (p *umlWriter) jumpIndirect(from, to, note string) {
+	p.writeConn(from, to, "--[#gray,dashed]>", note)
+}
+
+// terminalAlias returns a terminal state of the given kind, it is declared once per SM
+func (p *umlWriter) terminalAlias(d *SMDecl, kind string) string {
+	key := fmt.Sprintf("T%02d_%s", d.SeqNo, strings.ToUpper(strings.Trim(kind, "<>")))
+	if p.terminals == nil {
+		p.terminals = map[string]string{}
+	}
+	if alias := p.terminals[key]; alias != "" {
+		return alias
+	}
+	p.terminals[key] = key
+	p.line(`state "`, strings.Trim(kind, "<>"), `" as `, key, " <<", strings.Trim(kind, "<>"), ">>")
+	return key
+}
+
+func (p *umlWriter) jumpTerminal(from, to, kind, note string) {
+	switch kind {
+	case TerminalError:
+		p.writeConn(from, to, "--[#red]>", note)
+	case TerminalPanic:
+		p.writeConn(from, to, "--[#red,bold]>", note)
+	default:
+		p.writeConn(from, to, "--[#blue,dashed]>", note)
+	}
+}
+
+func (p *umlWriter) jumpFixed(from, to, note string) {
+	p.writeConn(from, to, "-->", note)
+}
+
+func (p *umlWriter) jumpCond(from, to, note string) {
+	p.writeConn(from, to, "--[dashed]>", note)
+}
+
+func (p *umlWriter) jump(from, to, note string, conditional bool) {
+	if conditional {
+		p.jumpCond(from, to, note)
+	} else {
+		p.jumpFixed(from, to, note)
+	}
+}
+
+func (p *umlWriter) stepAlias(d *SMDecl, name string, step *MethodDecl) string {
+	if step != nil {
+		return fmt.Sprintf("T%02d_S%03d", d.SeqNo, step.StepNo)
+	}
+
+	stepAlias := p.newNamelessStep(d, name, "")
+	p.line(stepAlias, " : ", d.Title())
+	p.line(stepAlias, " : UNKNOWN ")
+	return stepAlias
+}
+
+func (p *umlWriter) newNamelessStep(d *SMDecl, name, stereotype string) string {
+	p.unknownId++
+	stepAlias := fmt.Sprintf("T%02d_U%03d", d.SeqNo, p.unknownId)
+	as := ""
+	switch {
+	case name != "":
+		name = quoteName(name)
+		as = " as "
+	case stereotype == "":
+		return stepAlias
+	}
+	p.line("state ", name, as, stepAlias, stereotype)
+	return stepAlias
+}
+
+func (p *umlWriter) writeConn(fromStep, toStep string, line, note string) {
+	if note == "" {
+		p.line(fromStep, " ", line, " ", toStep)
+	} else {
+		p.line(fromStep, " ", line, " ", toStep, " : ", note)
+	}
+}
+
+// writeExchanges writes a diagram of data shared and published between SMs, grouped by keys
+func (p *umlWriter) writeExchanges(decls []*SMDecl) {
+	type keyUsage struct {
+		sm   *SMDecl
+		step *MethodDecl
+		ex   MethodExchange
+	}
+
+	keys := map[string][]keyUsage{}
+	for _, d := range decls {
+		for _, step := range d.Steps {
+			for _, ex := range step.Exchanges {
+				keys[ex.Key] = append(keys[ex.Key], keyUsage{d, step, ex})
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	keyNames := make([]string, 0, len(keys))
+	for k := range keys {
+		keyNames = append(keyNames, k)
+	}
+	sort.Strings(keyNames)
+
+	p.line(`@startuml`)
+	p.line(`left to right direction`)
+	for _, d := range decls {
+		p.line("rectangle ", quoteName(d.Title()), " as ", smAlias(d))
+	}
+
+	for i, k := range keyNames {
+		keyAlias := fmt.Sprintf("K%03d", i+1)
+		name := k
+		if name == "" {
+			name = "<no key>"
+		}
+		p.line("queue ", quoteName(name), " as ", keyAlias)
+
+		usages := keys[k]
+		sort.SliceStable(usages, func(i, j int) bool {
+			if usages[i].sm.SeqNo != usages[j].sm.SeqNo {
+				return usages[i].sm.SeqNo < usages[j].sm.SeqNo
+			}
+			return usages[i].step.StepNo < usages[j].step.StepNo
+		})
+
+		for _, u := range usages {
+			note := u.ex.Operation + `\n` + u.step.Name
+			switch {
+			case u.ex.Kind.IsProvider():
+				p.writeConn(smAlias(u.sm), keyAlias, "-->", note)
+			case u.ex.Kind == ExchangeUnpublish:
+				p.writeConn(smAlias(u.sm), keyAlias, "-[#red,dashed]-x", note)
+			default:
+				p.writeConn(keyAlias, smAlias(u.sm), "..>", note)
+			}
+		}
+	}
+	p.line(`@enduml`)
+}
+
+// writeSyncReport writes a diagram of sync objects and SMs that contend on them
+func (p *umlWriter) writeSyncReport(decls []*SMDecl) {
+	type linkUsage struct {
+		sm   *SMDecl
+		step *MethodDecl
+		op   MethodSyncOp
+	}
+
+	links := map[string][]linkUsage{}
+	for _, d := range decls {
+		for _, step := range d.Steps {
+			for _, so := range step.SyncOps {
+				links[so.Link] = append(links[so.Link], linkUsage{d, step, so})
+			}
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+
+	linkNames := make([]string, 0, len(links))
+	for k := range links {
+		linkNames = append(linkNames, k)
+	}
+	sort.Strings(linkNames)
+
+	p.line(`@startuml`)
+	p.line(`left to right direction`)
+	for _, d := range decls {
+		p.line("rectangle ", quoteName(d.Title()), " as ", smAlias(d))
+	}
+
+	for i, k := range linkNames {
+		linkAlias := fmt.Sprintf("L%03d", i+1)
+		name := k
+		if name == "" {
+			name = "<all>"
+		}
+		p.line("node ", quoteName(name), " as ", linkAlias)
+
+		usages := links[k]
+		sort.SliceStable(usages, func(i, j int) bool {
+			if usages[i].sm.SeqNo != usages[j].sm.SeqNo {
+				return usages[i].sm.SeqNo < usages[j].sm.SeqNo
+			}
+			return usages[i].step.StepNo < usages[j].step.StepNo
+		})
+
+		for _, u := range usages {
+			p.writeConn(smAlias(u.sm), linkAlias, "-->", u.op.Operation+`\n`+u.step.Name)
+		}
+	}
+	p.line(`@enduml`)
+}
+
+func smAlias(d *SMDecl) string {
+	return fmt.Sprintf("T%02d", d.SeqNo)
+}
+
+// escapeDesc keeps a text on one line of PlantUML, line breaks are replaced by PlantUML `\n`
+func escapeDesc(s string) string {
+	return descReplacer.Replace(s)
+}
+
+var descReplacer = strings.NewReplacer("\r\n", `\n`, "\n", `\n`, "\r", `\n`, "\t", " ")
+
+// quoteName quotes a name of PlantUML element, there is no escape for double quotes inside of it
+func quoteName(s string) string {
+	return `"` + escapeDesc(strings.ReplaceAll(s, `"`, `'`)) + `"`
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for k := range set {
+		if k != "" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func joinLines(s, line string) string {
+	if s == "" {
+		return line
+	}
+	return s + `\n` + line
+}
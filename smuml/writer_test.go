@@ -0,0 +1,48 @@
+package smuml
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQuoteName(t *testing.T) {
+	for s, want := range map[string]string{
+		`a "b"`:     `"a 'b'"`,
+		"a\nb\tc":   `"a\nb c"`,
+		`a\b`:       `"a\b"`,
+		"x\r\ny\rz": `"x\ny\nz"`,
+	} {
+		if got := quoteName(s); got != want {
+			t.Errorf("%q: got %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestConditionIsNotQuoted(t *testing.T) {
+	const src = `
+type SMCond struct {
+	smachine.StateMachineDeclTemplate
+	name string
+}
+
+func (s *SMCond) Init(ctx smachine.InitializationContext) smachine.StateUpdate {
+	if s.name == "x" {
+		return ctx.Stop()
+	}
+	return ctx.Jump(s.Init)
+}
+`
+	requireEdges(t, renderEdges(t, src), `Init --> [*] : [s.name=="x"]`)
+}
+
+func TestZeroWriter(t *testing.T) {
+	decls := newTestFileSet(t, testLabelsSM).Resolve()
+	b := bytes.Buffer{}
+	w := umlWriter{out: bufio.NewWriter(&b)}
+	w.writeUML(decls)
+	if w.err != nil || w.out.Flush() != nil || !strings.HasPrefix(b.String(), "@startuml\n") {
+		t.Fatalf("unexpected output: %v\n%s", w.err, b.String())
+	}
+}